@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	redisStore "github.com/gin-contrib/sessions/redis"
+	"github.com/gin-gonic/gin"
+	redis "github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+
+	handlers "github.com/Jovdza012/gin_chapter_2/handlers"
+)
+
+// App wires the handlers to MongoDB, Redis and the router.
+type App struct {
+	config      Config
+	logger      *slog.Logger
+	router      *gin.Engine
+	mongoClient *mongo.Client
+	db          *mongo.Database
+	redisClient *redis.Client
+	// mongoBreaker is open while MongoDB is unreachable
+	mongoBreaker *handlers.Breaker
+	// recipeSchema is reloaded on SIGHUP, nil without RECIPE_SCHEMA
+	recipeSchema *handlers.RecipeSchema
+
+	authHandler     *handlers.AuthHandler
+	recipesHandler  *handlers.RecipesHandler
+	healthHandler   *handlers.HealthHandler
+	imagesHandler   *handlers.ImagesHandler
+	auditHandler    *handlers.AuditHandler
+	webhooksHandler *handlers.WebhooksHandler
+	apiKeysHandler  *handlers.APIKeysHandler
+	docsHandler     *handlers.DocsHandler
+	rateLimiter     *handlers.RateLimiter
+}
+
+// NewApp connects to MongoDB and Redis, makes sure the indexes exist, runs
+// the pending migrations and builds the router.
+func NewApp(config Config, logger *slog.Logger) (*App, error) {
+	ctx := context.Background()
+	app := &App{config: config, logger: logger}
+	if err := app.connect(ctx); err != nil {
+		return nil, err
+	}
+	client, db := app.mongoClient, app.db
+	if err := ensureIndexes(ctx, db); err != nil {
+		return nil, fmt.Errorf("failed to create indexes: %w", err)
+	}
+	if err := migrate(ctx, db, app.redisClient); err != nil {
+		return nil, fmt.Errorf("failed to migrate: %w", err)
+	}
+
+	if config.RecipeSchema != "" {
+		schema, err := handlers.LoadRecipeSchema(config.RecipeSchema)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load recipe schema: %w", err)
+		}
+		app.recipeSchema = schema
+	}
+
+	// Hanlder initializetion
+	app.recipesHandler = handlers.NewRecipesHandler(ctx, db.Collection("recipes"), app.redisClient, config.CacheTTL, app.recipeSchema, config.XMLOutput, config.DuplicateThreshold, config.MaxPageLimit, config.ReadSecondary)
+	app.imagesHandler = handlers.NewImagesHandler(app.recipesHandler, config.ImagesDir, config.BasePath)
+	app.authHandler = handlers.NewAuthHandler(ctx, db.Collection("users"), app.redisClient, config.JWTSecret, config.MaxFailedLogins, config.LockoutDuration, app.mailer(), config.PublicURL+config.BasePath, app.passwordPolicy())
+	app.healthHandler = handlers.NewHealthHandler(ctx, client, app.redisClient)
+	app.auditHandler = handlers.NewAuditHandler(ctx, db.Collection("audit"), config.MaxPageLimit)
+	app.webhooksHandler = handlers.NewWebhooksHandler(ctx, db.Collection("webhooks"))
+	app.apiKeysHandler = handlers.NewAPIKeysHandler(ctx, db.Collection("apikeys"))
+	app.docsHandler = handlers.NewDocsHandler(swaggerSpec, config.BasePath)
+	app.rateLimiter = handlers.NewRateLimiter(app.redisClient, config.RateLimit, config.RateWindow)
+
+	if err := app.setupRouter(); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// connect opens the MongoDB and Redis connections, failing unless both
+// answer a ping.
+func (app *App) connect(ctx context.Context) error {
+	// MongoDb connection
+	// the operation timeout bounds every call made without its own deadline,
+	// so a stuck MongoDB fails requests with a timeout instead of hanging them.
+	// Reads and writes interrupted by a failover or a dropped connection are
+	// retried once by the driver, which keeps reconnecting in the background.
+	app.mongoBreaker = handlers.NewBreaker()
+	monitor := otelmongo.NewMonitor()
+	if app.config.SlowQueryThreshold > 0 {
+		monitor = handlers.SlowQueryMonitor(app.config.SlowQueryThreshold, monitor)
+	}
+	clientOptions := options.Client().ApplyURI(app.config.MongoURI).
+		SetRetryReads(true).
+		SetRetryWrites(true).
+		SetMaxPoolSize(app.config.MongoMaxPoolSize).
+		SetMinPoolSize(app.config.MongoMinPoolSize).
+		SetConnectTimeout(app.config.MongoConnectTimeout).
+		SetServerSelectionTimeout(app.config.MongoConnectTimeout).
+		SetTimeout(app.config.MongoOperationTimeout).
+		SetMonitor(monitor).
+		SetServerMonitor(app.mongoBreaker.ServerMonitor())
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return err
+	}
+	app.mongoClient = client
+	pingCtx, cancel := context.WithTimeout(ctx, app.config.MongoConnectTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx, readpref.Primary()); err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	app.logger.Info("Connected to MongoDB")
+	app.db = client.Database(app.config.MongoDatabase)
+
+	app.redisClient = redis.NewClient(app.redisOptions())
+	status, err := app.redisClient.Ping().Result()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	app.logger.Info("Connected to Redis", "status", status)
+	return nil
+}
+
+// redisOptions retries commands failing on a broken connection over a fresh
+// one, so a Redis restart only delays the commands sent meanwhile.
+func (app *App) redisOptions() *redis.Options {
+	return &redis.Options{
+		Addr:            app.config.RedisAddr,
+		Password:        app.config.RedisPassword,
+		DB:              app.config.RedisDB,
+		MaxRetries:      int(app.config.RedisMaxRetries),
+		MinRetryBackoff: 100 * time.Millisecond,
+		MaxRetryBackoff: time.Second,
+	}
+}
+
+// passwordPolicy is the policy set by the PASSWORD_* variables.
+func (app *App) passwordPolicy() handlers.PasswordPolicy {
+	config := app.config
+	return handlers.NewPasswordPolicy(int(config.PasswordMinLength),
+		config.PasswordRequireLower, config.PasswordRequireUpper,
+		config.PasswordRequireDigit, config.PasswordRequireSymbol, config.PasswordRejectCommon)
+}
+
+// mailer sends emails through SMTP_ADDR, or only logs them when it is unset.
+func (app *App) mailer() handlers.Mailer {
+	if app.config.SMTPAddr == "" {
+		return handlers.LogMailer{}
+	}
+	return handlers.SMTPMailer{
+		Addr:     app.config.SMTPAddr,
+		From:     app.config.SMTPFrom,
+		Username: app.config.SMTPUsername,
+		Password: app.config.SMTPPassword,
+	}
+}
+
+// sessionStore keeps the sessions in Redis. Cookies are signed with the
+// first SESSION_SECRET and accepted when signed with any of them.
+func (app *App) sessionStore() (redisStore.Store, error) {
+	keyPairs := make([][]byte, 0, 2*len(app.config.SessionSecrets))
+	for _, secret := range app.config.SessionSecrets {
+		// no encryption key, the cookie only holds the session id
+		keyPairs = append(keyPairs, []byte(secret), nil)
+	}
+	store, err := redisStore.NewStoreWithDB(10, "tcp", app.config.RedisAddr, app.config.RedisPassword,
+		strconv.Itoa(app.config.RedisDB), keyPairs...)
+	if err != nil {
+		return nil, err
+	}
+
+	sameSite := map[string]http.SameSite{
+		"lax":    http.SameSiteLaxMode,
+		"strict": http.SameSiteStrictMode,
+		"none":   http.SameSiteNoneMode,
+	}[app.config.SessionSameSite]
+	maxAge := int(app.config.SessionMaxAge / time.Second)
+	store.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   app.config.SessionSecure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	// also bounds the age the cookie signature is accepted for and the TTL
+	// of the session in Redis
+	err, rediStore := redisStore.GetRedisStore(store)
+	if err != nil {
+		return nil, err
+	}
+	rediStore.SetMaxAge(maxAge)
+	return store, nil
+}
+
+func (app *App) setupRouter() error {
+	// LOG_FORMAT=json swaps gin's pretty logger for one JSON line per request
+	router := gin.New()
+	// X-Forwarded-For is only believed from these hops, so a client can't
+	// pick the IP that rate limiting and the logs see
+	if err := router.SetTrustedProxies(app.config.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	router.Use(handlers.RequestID(nil))
+	if app.config.OTLPEndpoint != "" {
+		router.Use(otelgin.Middleware(app.config.ServiceName), handlers.TraceAttributes())
+	}
+	if len(app.config.CORSOrigins) > 0 {
+		router.Use(handlers.CORS(app.config.CORSOrigins, app.config.CORSAllowedHeaders, app.config.CORSExposedHeaders, app.config.CORSMaxAge))
+	}
+	if app.config.Compression {
+		router.Use(handlers.Compress(app.config.CompressionMinSize))
+	}
+	router.Use(handlers.Logger(app.logger), handlers.Recovery())
+	router.Use(handlers.MetricsMiddleware())
+	// uploads and imports are larger than any JSON body and bounded by their
+	// handlers instead
+	base := app.config.BasePath
+	router.Use(handlers.BodyLimit(app.config.MaxBodySize, base+"/recipes/:id/image", base+"/recipes/:id/images", base+"/recipes/import"))
+	if app.config.RequestTimeout > 0 {
+		router.Use(handlers.Timeout(app.config.RequestTimeout))
+	}
+	store, err := app.sessionStore()
+	if err != nil {
+		return fmt.Errorf("failed to create session store: %w", err)
+	}
+	router.Use(sessions.Sessions("recipes_api", store))
+
+	// AUTH_MODE=jwt switches from cookie sessions to bearer tokens
+	authMiddleware := app.authHandler.AuthMiddleware()
+	signInHandler := app.authHandler.SignInHandler
+	if app.config.AuthMode == "jwt" {
+		authMiddleware = app.authHandler.JWTMiddleware()
+		signInHandler = app.authHandler.JWTSignInHandler
+	}
+
+	// everything but the probes and metrics lives under BASE_PATH. While
+	// MongoDB is unreachable only the cached reads and the docs are served.
+	api := router.Group(base)
+	api.Use(app.mongoBreaker.Middleware(base+"/recipes", base+"/recipes/:id", base+"/swagger.json", base+"/swagger/*any"))
+	authorized := api.Group("/")
+	authorized.Use(app.apiKeysHandler.Authenticate(authMiddleware), handlers.OrgScope(), app.rateLimiter.Middleware())
+	{
+		authorized.POST("/recipes", app.recipesHandler.NewRecipeHandler)
+		authorized.POST("/recipes/bulk", app.recipesHandler.BulkCreateHandler)
+		authorized.DELETE("/recipes", app.recipesHandler.BulkDeleteHandler)
+		authorized.POST("/recipes/suggest", app.recipesHandler.SuggestRecipesHandler)
+		authorized.GET("/recipes", app.recipesHandler.ListRecipesHandler)
+		authorized.GET("/recipes/search", app.recipesHandler.SearchRecipesHandler)
+		authorized.GET("/recipes/autocomplete", app.recipesHandler.AutocompleteHandler)
+		authorized.GET("/recipes/count", app.recipesHandler.CountRecipesHandler)
+		authorized.GET("/recipes/facets", app.recipesHandler.FacetsHandler)
+		authorized.GET("/recipes/favorites", app.recipesHandler.ListFavoritesHandler)
+		authorized.GET("/recipes/popular", app.recipesHandler.PopularRecipesHandler)
+		authorized.GET("/recipes/stream", app.recipesHandler.StreamRecipesHandler)
+		authorized.GET("/recipes/export", app.authHandler.RequireRole("admin"), app.recipesHandler.ExportRecipesHandler)
+		authorized.GET("/recipes/export.csv", app.recipesHandler.ExportCSVHandler)
+		authorized.POST("/recipes/import", app.authHandler.RequireRole("admin"), app.recipesHandler.ImportRecipesHandler)
+		authorized.PUT("/recipes/:id", app.recipesHandler.UpdateRecipeHandler)
+		authorized.PATCH("/recipes/:id", app.recipesHandler.PatchRecipeHandler)
+		authorized.DELETE("/recipes/:id", app.recipesHandler.DeleteRecipeHandler)
+		authorized.GET("/recipes/:id", app.recipesHandler.GetOneRecipeHandler)
+		authorized.GET("/recipes/:id/nutrition", app.recipesHandler.NutritionHandler)
+		authorized.POST("/recipes/:id/restore", app.recipesHandler.RestoreRecipeHandler)
+		authorized.POST("/recipes/:id/transfer", app.recipesHandler.TransferRecipeHandler)
+		authorized.POST("/recipes/:id/duplicate", app.recipesHandler.DuplicateRecipeHandler)
+		authorized.POST("/recipes/:id/favorite", app.recipesHandler.FavoriteRecipeHandler)
+		authorized.DELETE("/recipes/:id/favorite", app.recipesHandler.UnfavoriteRecipeHandler)
+		authorized.POST("/recipes/:id/rating", app.recipesHandler.RateRecipeHandler)
+		authorized.POST("/recipes/:id/comments", app.recipesHandler.NewCommentHandler)
+		authorized.GET("/recipes/:id/comments", app.recipesHandler.ListCommentsHandler)
+		authorized.DELETE("/recipes/:id/comments/:commentId", app.recipesHandler.DeleteCommentHandler)
+		authorized.POST("/recipes/:id/image", app.imagesHandler.UploadImageHandler)
+		authorized.GET("/recipes/:id/image", app.imagesHandler.GetImageHandler)
+		authorized.POST("/recipes/:id/images", app.imagesHandler.AddGalleryImageHandler)
+		authorized.PUT("/recipes/:id/images/order", app.imagesHandler.OrderGalleryImagesHandler)
+		authorized.GET("/recipes/:id/images/:imageId", app.imagesHandler.GetGalleryImageHandler)
+		authorized.DELETE("/recipes/:id/images/:imageId", app.imagesHandler.DeleteGalleryImageHandler)
+		authorized.DELETE("/recipes/:id/permanent", app.authHandler.RequireRole("admin"), app.recipesHandler.PurgeRecipeHandler)
+
+		authorized.GET("/me", app.authHandler.ProfileHandler)
+		authorized.GET("/me/recipes", app.recipesHandler.MyRecipesHandler)
+		authorized.POST("/me/password", app.authHandler.ChangePasswordHandler)
+
+		authorized.PUT("/users/:username/roles", app.authHandler.RequireRole("admin"), app.authHandler.UpdateRolesHandler)
+		authorized.PUT("/users/:username/org", app.authHandler.RequireRole("superadmin"), app.authHandler.UpdateOrgHandler)
+		authorized.GET("/audit", app.authHandler.RequireRole("admin"), app.auditHandler.ListAuditHandler)
+		authorized.POST("/webhooks", app.authHandler.RequireRole("admin"), app.webhooksHandler.NewWebhookHandler)
+		authorized.GET("/webhooks", app.authHandler.RequireRole("admin"), app.webhooksHandler.ListWebhooksHandler)
+		authorized.DELETE("/webhooks/:id", app.authHandler.RequireRole("admin"), app.webhooksHandler.DeleteWebhookHandler)
+		authorized.POST("/apikeys", app.authHandler.RequireRole("admin"), app.apiKeysHandler.CreateAPIKeyHandler)
+		authorized.DELETE("/apikeys/:id", app.authHandler.RequireRole("admin"), app.apiKeysHandler.RevokeAPIKeyHandler)
+	}
+	api.POST("/signup", app.rateLimiter.Middleware(), app.authHandler.SignUpHandler)
+	api.POST("/signin", app.rateLimiter.Middleware(), signInHandler)
+	api.GET("/verify", app.rateLimiter.Middleware(), app.authHandler.VerifyEmailHandler)
+	api.POST("/password/forgot", app.rateLimiter.Middleware(), app.authHandler.ForgotPasswordHandler)
+	api.POST("/password/reset", app.rateLimiter.Middleware(), app.authHandler.ResetPasswordHandler)
+	api.GET("/password/policy", app.authHandler.PasswordPolicyHandler)
+	api.POST("/signout", app.authHandler.SignOutHandler)
+	api.POST("/refresh", app.authHandler.RefreshHandler)
+	api.GET("/swagger.json", app.docsHandler.SpecHandler)
+	api.GET("/swagger/*any", app.docsHandler.UIHandler)
+	router.GET("/health", app.healthHandler.LivenessHandler)
+	router.GET("/ready", app.healthHandler.ReadinessHandler)
+	router.GET("/metrics", handlers.MetricsHandler())
+
+	router.NoRoute(handlers.NotFoundHandler)
+
+	app.router = router
+	return nil
+}
+
+// Router returns the HTTP handler of the API, e.g. to drive it with httptest.
+func (app *App) Router() http.Handler {
+	return app.router
+}
+
+// Run serves the API until SIGINT or SIGTERM, then drains in-flight requests
+// for up to ShutdownTimeout and closes the connections. SIGHUP reloads the
+// recipe schema.
+func (app *App) Run() error {
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	go app.healthHandler.WatchDependencies(watchCtx, 15*time.Second)
+	go app.recipesHandler.WatchChanges(watchCtx)
+	go app.recipesHandler.FlushViews(watchCtx, app.config.ViewsFlushInterval)
+
+	listener, err := app.listen()
+	if err != nil {
+		stopWatching()
+		app.Close(context.Background())
+		return err
+	}
+	app.logger.Info("Listening", "addr", app.config.ListenAddr)
+
+	server := &http.Server{
+		Addr:    app.config.ListenAddr,
+		Handler: app.router,
+	}
+	server.RegisterOnShutdown(app.recipesHandler.CloseStreams)
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+wait:
+	for {
+		select {
+		case err := <-serverErr:
+			stopWatching()
+			app.Close(context.Background())
+			return err
+		case <-hangup:
+			app.reloadSchema()
+		case <-quit:
+			break wait
+		}
+	}
+	app.logger.Info("Shutting down server...")
+	stopWatching()
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		app.logger.Warn("Server forced to shutdown", "error", err)
+	}
+	app.Close(ctx)
+	app.logger.Info("Server exited")
+	return nil
+}
+
+// reloadSchema reads RECIPE_SCHEMA again, keeping the current schema when
+// the new one doesn't compile.
+func (app *App) reloadSchema() {
+	if app.recipeSchema == nil {
+		return
+	}
+	if err := app.recipeSchema.Reload(); err != nil {
+		app.logger.Error("Failed to reload recipe schema, keeping the current one", "error", err)
+		return
+	}
+	app.logger.Info("Reloaded recipe schema", "path", app.config.RecipeSchema)
+}
+
+// listen binds ListenAddr, replacing a socket file left behind by a previous
+// run when it is a Unix socket path.
+func (app *App) listen() (net.Listener, error) {
+	if !isUnixSocket(app.config.ListenAddr) {
+		return net.Listen("tcp", app.config.ListenAddr)
+	}
+	if err := os.Remove(app.config.ListenAddr); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", app.config.ListenAddr)
+}
+
+// Close disconnects from MongoDB and Redis, skipping whichever connect never
+// got to.
+func (app *App) Close(ctx context.Context) {
+	if app.mongoClient != nil {
+		if err := app.mongoClient.Disconnect(ctx); err != nil {
+			app.logger.Warn("Failed to disconnect from MongoDB", "error", err)
+		}
+	}
+	if app.redisClient != nil {
+		if err := app.redisClient.Close(); err != nil {
+			app.logger.Warn("Failed to close Redis connection", "error", err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+
+	handlers "github.com/Jovdza012/gin_chapter_2/handlers"
+)
+
+// command is an admin task run from the command line instead of serving the
+// API. It gets an App connected to MongoDB and Redis, without handlers.
+type command struct {
+	usage string
+	run   func(ctx context.Context, app *App, args []string) error
+}
+
+var commands = map[string]command{
+	"createuser": {
+		usage: "createuser --username NAME [--password PASSWORD] [--email EMAIL] [--org ORG] [--role ROLE,...]",
+		run:   createUserCommand,
+	},
+	"setrole": {
+		usage: "setrole --username NAME --role ROLE,... (an empty --role removes all roles)",
+		run:   setRoleCommand,
+	},
+	"reindex": {
+		usage: "reindex [--rebuild]",
+		run:   reindexCommand,
+	},
+	"migrate": {
+		usage: "migrate",
+		run:   migrateCommand,
+	},
+	"seed": {
+		usage: "seed [--username NAME] [--password PASSWORD]",
+		run:   seedCommand,
+	},
+}
+
+// runCommand connects to MongoDB and Redis and runs the named command.
+func runCommand(config Config, logger *slog.Logger, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", name, commandsUsage())
+	}
+
+	ctx := context.Background()
+	app := &App{config: config, logger: logger}
+	defer app.Close(ctx)
+	if err := app.connect(ctx); err != nil {
+		return err
+	}
+	return cmd.run(ctx, app, args)
+}
+
+func commandsUsage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var usage strings.Builder
+	usage.WriteString("commands:")
+	for _, name := range names {
+		usage.WriteString("\n  " + commands[name].usage)
+	}
+	return usage.String()
+}
+
+// parseRoles splits a comma separated list of roles, dropping empty ones.
+func parseRoles(list string) []string {
+	roles := []string{}
+	for _, role := range strings.Split(list, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// createUserCommand inserts a verified account. Without --password the
+// password is read from the first line of stdin, keeping it out of the shell
+// history and the process list.
+func createUserCommand(ctx context.Context, app *App, args []string) error {
+	flags := flag.NewFlagSet("createuser", flag.ContinueOnError)
+	username := flags.String("username", "", "name of the account")
+	password := flags.String("password", "", "password of the account, read from stdin when empty")
+	email := flags.String("email", "", "email address of the account")
+	org := flags.String("org", handlers.DefaultOrg, "organization of the account")
+	roles := flags.String("role", "", "comma separated roles, e.g. admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("--username is required")
+	}
+	if *password == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if err := insertUser(ctx, app.db, app.passwordPolicy(), *username, *password, *email, *org, parseRoles(*roles)); err != nil {
+		return err
+	}
+
+	fmt.Println("Created user", *username)
+	return nil
+}
+
+// errUserExists is returned by insertUser when the username is taken.
+var errUserExists = errors.New("user already exists")
+
+// insertUser stores a verified account of org with a bcrypt hash of password,
+// which must follow policy like those set through the API.
+func insertUser(ctx context.Context, db *mongo.Database, policy handlers.PasswordPolicy, username, password, email, org string, roles []string) error {
+	if broken := policy.Check(password); len(broken) > 0 {
+		rules := make([]string, len(broken))
+		for i, rule := range broken {
+			rules[i] = rule.Message
+		}
+		return fmt.Errorf("the password %s", strings.Join(rules, ", "))
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user := bson.M{
+		"username":  username,
+		"password":  string(hashedPassword),
+		"roles":     roles,
+		"orgId":     org,
+		"createdAt": time.Now(),
+	}
+	if email != "" {
+		user["email"] = email
+	}
+	if _, err := db.Collection("users").InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: %s", errUserExists, username)
+		}
+		return err
+	}
+	return nil
+}
+
+// setRoleCommand replaces the roles of an account, like PUT
+// /users/:username/roles does.
+func setRoleCommand(ctx context.Context, app *App, args []string) error {
+	flags := flag.NewFlagSet("setrole", flag.ContinueOnError)
+	username := flags.String("username", "", "name of the account")
+	roles := flags.String("role", "", "comma separated roles, e.g. admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("--username is required")
+	}
+
+	list := parseRoles(*roles)
+	result, err := app.db.Collection("users").UpdateOne(ctx, bson.M{
+		"username": *username,
+	}, bson.M{"$set": bson.M{"roles": list}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("user %s not found", *username)
+	}
+
+	fmt.Printf("Roles of %s set to [%s]\n", *username, strings.Join(list, ","))
+	return nil
+}
+
+// reindexCommand creates the missing indexes. With --rebuild the indexes are
+// dropped and created again first, which picks up changed definitions.
+func reindexCommand(ctx context.Context, app *App, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	rebuild := flags.Bool("rebuild", false, "drop and recreate the indexes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *rebuild {
+		if err := dropIndexes(ctx, app.db); err != nil {
+			return fmt.Errorf("failed to drop indexes: %w", err)
+		}
+	}
+	return ensureIndexes(ctx, app.db)
+}
+
+func migrateCommand(ctx context.Context, app *App, args []string) error {
+	if err := flag.NewFlagSet("migrate", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+	if err := migrate(ctx, app.db, app.redisClient); err != nil {
+		return err
+	}
+
+	fmt.Println("Migrations are up to date")
+	return nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Config holds everything the API reads from the environment.
+type Config struct {
+	MongoURI              string
+	MongoDatabase         string
+	MongoMaxPoolSize      uint64
+	MongoMinPoolSize      uint64
+	MongoConnectTimeout   time.Duration
+	MongoOperationTimeout time.Duration
+	SlowQueryThreshold    time.Duration
+
+	RedisAddr       string
+	RedisPassword   string
+	RedisDB         int
+	RedisMaxRetries uint64
+
+	AuthMode        string
+	JWTSecret       string
+	MaxFailedLogins int64
+	LockoutDuration time.Duration
+	RateLimit       int64
+	RateWindow      time.Duration
+
+	PasswordMinLength     int64
+	PasswordRequireLower  bool
+	PasswordRequireUpper  bool
+	PasswordRequireDigit  bool
+	PasswordRequireSymbol bool
+	PasswordRejectCommon  bool
+
+	SessionSecrets  []string
+	SessionMaxAge   time.Duration
+	SessionSecure   bool
+	SessionSameSite string
+
+	PublicURL    string
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+
+	ListenAddr         string
+	BasePath           string
+	RequestTimeout     time.Duration
+	CacheTTL           time.Duration
+	ImagesDir          string
+	RecipeSchema       string
+	CORSOrigins        []string
+	CORSAllowedHeaders []string
+	CORSExposedHeaders []string
+	CORSMaxAge         time.Duration
+	TrustedProxies     []string
+	LogFormat          string
+	LogLevel           slog.Level
+	OTLPEndpoint       string
+	ServiceName        string
+	ShutdownTimeout    time.Duration
+
+	ViewsFlushInterval time.Duration
+
+	XMLOutput          bool
+	DuplicateThreshold float64
+	MaxPageLimit       int64
+	ReadSecondary      bool
+	Compression        bool
+	CompressionMinSize int
+	MaxBodySize        int64
+}
+
+// LoadConfig reads the configuration from the environment, applying the
+// defaults for anything unset. Every missing or invalid variable is reported
+// in the returned error, not just the first one.
+func LoadConfig() (Config, error) {
+	loader := &configLoader{}
+	config := Config{
+		MongoURI:              loader.required("MONGO_URI"),
+		MongoDatabase:         loader.required("MONGO_DATABASE"),
+		MongoMaxPoolSize:      loader.uint("MONGO_MAX_POOL_SIZE", 100),
+		MongoMinPoolSize:      loader.uint("MONGO_MIN_POOL_SIZE", 0),
+		MongoConnectTimeout:   loader.duration("MONGO_CONNECT_TIMEOUT", 10*time.Second, false),
+		MongoOperationTimeout: loader.duration("MONGO_OPERATION_TIMEOUT", 5*time.Second, false),
+		SlowQueryThreshold:    loader.duration("SLOW_QUERY_THRESHOLD", 200*time.Millisecond, true),
+		RedisAddr:             loader.string("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:         os.Getenv("REDIS_PASSWORD"),
+		RedisDB:               int(loader.uint("REDIS_DB", 0)),
+		RedisMaxRetries:       loader.uint("REDIS_MAX_RETRIES", 3),
+		AuthMode:              loader.oneOf("AUTH_MODE", "session", "jwt"),
+		JWTSecret:             loader.required("JWT_SECRET"),
+		MaxFailedLogins:       loader.positiveInt("LOCKOUT_THRESHOLD", 5),
+		LockoutDuration:       loader.duration("LOCKOUT_DURATION", 15*time.Minute, false),
+		RateLimit:             loader.positiveInt("RATE_LIMIT", 100),
+		RateWindow:            loader.duration("RATE_WINDOW", time.Minute, false),
+		PasswordMinLength:     loader.positiveInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequireLower:  loader.bool("PASSWORD_REQUIRE_LOWER", false),
+		PasswordRequireUpper:  loader.bool("PASSWORD_REQUIRE_UPPER", false),
+		PasswordRequireDigit:  loader.bool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSymbol: loader.bool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordRejectCommon:  loader.bool("PASSWORD_REJECT_COMMON", true),
+		SessionMaxAge:         loader.duration("SESSION_MAX_AGE", 24*time.Hour, false),
+		SessionSameSite:       loader.oneOf("SESSION_SAME_SITE", "lax", "strict", "none"),
+		PublicURL:             loader.string("PUBLIC_URL", "http://localhost:8080"),
+		SMTPAddr:              os.Getenv("SMTP_ADDR"),
+		SMTPFrom:              os.Getenv("SMTP_FROM"),
+		SMTPUsername:          os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:          os.Getenv("SMTP_PASSWORD"),
+		ListenAddr:            loader.listenAddr(),
+		BasePath:              loader.basePath("BASE_PATH"),
+		TrustedProxies:        loader.trustedProxies("TRUSTED_PROXIES"),
+		RequestTimeout:        loader.duration("REQUEST_TIMEOUT", 30*time.Second, true),
+		CacheTTL:              loader.duration("RECIPES_CACHE_TTL", 10*time.Minute, true),
+		ImagesDir:             loader.string("IMAGES_DIR", "images"),
+		RecipeSchema:          os.Getenv("RECIPE_SCHEMA"),
+		CORSAllowedHeaders:    loader.headers("CORS_ALLOWED_HEADERS", "Authorization", "Content-Type", "X-Request-ID", "X-API-Key", "If-Match", "If-None-Match", "Idempotency-Key", "X-Org-ID"),
+		CORSExposedHeaders:    loader.headers("CORS_EXPOSED_HEADERS", "X-Request-ID", "X-Cache", "ETag", "Link", "Location", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-Limit-Clamped", "X-Search-Mode", "Idempotent-Replayed"),
+		CORSMaxAge:            loader.duration("CORS_MAX_AGE", time.Hour, true),
+		LogFormat:             loader.oneOf("LOG_FORMAT", "text", "json"),
+		LogLevel:              loader.logLevel("LOG_LEVEL", slog.LevelInfo),
+		OTLPEndpoint:          os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		ServiceName:           loader.string("OTEL_SERVICE_NAME", "recipes-api"),
+		ShutdownTimeout:       loader.duration("SHUTDOWN_TIMEOUT", 10*time.Second, true),
+		ViewsFlushInterval:    loader.duration("VIEWS_FLUSH_INTERVAL", time.Minute, false),
+		XMLOutput:             loader.bool("XML_OUTPUT", true),
+		DuplicateThreshold:    loader.fraction("DUPLICATE_THRESHOLD", 0.5),
+		MaxPageLimit:          loader.positiveInt("MAX_PAGE_LIMIT", 100),
+		ReadSecondary:         loader.bool("READ_SECONDARY", false),
+		Compression:           loader.bool("COMPRESSION", true),
+		CompressionMinSize:    int(loader.uint("COMPRESSION_MIN_SIZE", 1024)),
+		MaxBodySize:           loader.positiveInt("MAX_BODY_SIZE", 1<<20),
+	}
+	if value := os.Getenv("CORS_ORIGINS"); value != "" {
+		config.CORSOrigins = strings.Split(value, ",")
+	}
+	// the first secret signs new session cookies, the others are only checked
+	// so cookies signed before a rotation stay valid
+	if value := os.Getenv("SESSION_SECRET"); value != "" {
+		config.SessionSecrets = strings.Split(value, ",")
+	} else if config.AuthMode == "session" && os.Getenv("GIN_MODE") == "release" {
+		loader.problems = append(loader.problems, "SESSION_SECRET must be set when AUTH_MODE=session and GIN_MODE=release")
+	} else {
+		config.SessionSecrets = []string{"secret"}
+	}
+	// cookies are Secure by default whenever the API is served over HTTPS
+	config.SessionSecure = loader.bool("SESSION_SECURE", strings.HasPrefix(config.PublicURL, "https://"))
+	if config.SessionSameSite == "none" && !config.SessionSecure {
+		loader.problems = append(loader.problems, "SESSION_SAME_SITE=none requires SESSION_SECURE=true")
+	}
+	if config.SMTPAddr != "" && config.SMTPFrom == "" {
+		loader.problems = append(loader.problems, "SMTP_FROM must be set when SMTP_ADDR is")
+	}
+	// bcrypt only hashes the first 72 bytes
+	if config.PasswordMinLength > 72 {
+		loader.problems = append(loader.problems, "PASSWORD_MIN_LENGTH must not exceed 72")
+	}
+	if config.MongoMinPoolSize > config.MongoMaxPoolSize {
+		loader.problems = append(loader.problems, "MONGO_MIN_POOL_SIZE must not exceed MONGO_MAX_POOL_SIZE")
+	}
+
+	if len(loader.problems) > 0 {
+		return Config{}, errors.New("invalid configuration:\n  " + strings.Join(loader.problems, "\n  "))
+	}
+	return config, nil
+}
+
+// configLoader parses environment variables and collects every problem it
+// runs into so LoadConfig can report them together.
+type configLoader struct {
+	problems []string
+}
+
+func (loader *configLoader) invalid(name, value, expected string) {
+	loader.problems = append(loader.problems, fmt.Sprintf("%s=%q is invalid: expected %s", name, value, expected))
+}
+
+func (loader *configLoader) required(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		loader.problems = append(loader.problems, name+" is not set")
+	}
+	return value
+}
+
+func (loader *configLoader) string(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// oneOf returns the variable if it is one of allowed, the first of which is
+// the default.
+func (loader *configLoader) oneOf(name string, allowed ...string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return allowed[0]
+	}
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+	loader.invalid(name, value, "one of "+strings.Join(allowed, ", "))
+	return allowed[0]
+}
+
+// listenAddr returns LISTEN_ADDR, falling back to :PORT and then :8080. An
+// address starting with / or . is a Unix socket path.
+func (loader *configLoader) listenAddr() string {
+	name, value := "LISTEN_ADDR", os.Getenv("LISTEN_ADDR")
+	if value == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			return ":8080"
+		}
+		name, value = "PORT", ":"+port
+	}
+	if isUnixSocket(value) {
+		return value
+	}
+	_, port, err := net.SplitHostPort(value)
+	if err == nil {
+		var number uint64
+		number, err = strconv.ParseUint(port, 10, 16)
+		if number == 0 {
+			err = errors.New("port out of range")
+		}
+	}
+	if err != nil && name == "PORT" {
+		loader.invalid(name, port, "a port between 1 and 65535")
+		return ":8080"
+	}
+	if err != nil {
+		loader.invalid(name, value, "host:port with a port between 1 and 65535, or a socket path")
+		return ":8080"
+	}
+	return value
+}
+
+// basePath returns the path prefix of the API such as /api/v1, without the
+// trailing slash, so the root is the empty string.
+func (loader *configLoader) basePath(name string) string {
+	value := os.Getenv(name)
+	if value == "" || value == "/" {
+		return ""
+	}
+	if !strings.HasPrefix(value, "/") || strings.ContainsAny(value, " ?#:*") {
+		loader.invalid(name, value, "a path such as /api/v1")
+		return ""
+	}
+	return strings.TrimSuffix(value, "/")
+}
+
+// trustedProxies parses a comma-separated list of IPs and CIDRs, defaulting
+// to loopback. none trusts no proxy at all.
+func (loader *configLoader) trustedProxies(name string) []string {
+	value := os.Getenv(name)
+	switch value {
+	case "":
+		return []string{"127.0.0.1", "::1"}
+	case "none":
+		return nil
+	}
+	proxies := strings.Split(value, ",")
+	for i, proxy := range proxies {
+		proxies[i] = strings.TrimSpace(proxy)
+		if net.ParseIP(proxies[i]) == nil {
+			if _, _, err := net.ParseCIDR(proxies[i]); err != nil {
+				loader.invalid(name, value, "comma-separated IPs or CIDRs such as 10.0.0.0/8, or none")
+				return []string{"127.0.0.1", "::1"}
+			}
+		}
+	}
+	return proxies
+}
+
+// headers parses a comma-separated list of HTTP header names. none gives an
+// empty list.
+func (loader *configLoader) headers(name string, fallback ...string) []string {
+	value := os.Getenv(name)
+	switch value {
+	case "":
+		return fallback
+	case "none":
+		return nil
+	}
+	headers := strings.Split(value, ",")
+	for i, header := range headers {
+		headers[i] = strings.TrimSpace(header)
+		if !httpguts.ValidHeaderFieldName(headers[i]) {
+			loader.invalid(name, value, "comma-separated header names such as X-Request-ID, X-Cache, or none")
+			return fallback
+		}
+	}
+	return headers
+}
+
+// logLevel parses debug, info, warn or error, in any case.
+func (loader *configLoader) logLevel(name string, fallback slog.Level) slog.Level {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		loader.invalid(name, value, "one of debug, info, warn, error")
+		return fallback
+	}
+	return level
+}
+
+func (loader *configLoader) bool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		loader.invalid(name, value, "true or false")
+		return fallback
+	}
+	return parsed
+}
+
+func (loader *configLoader) uint(name string, fallback uint64) uint64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseUint(value, 10, 31)
+	if err != nil {
+		loader.invalid(name, value, "a non-negative integer")
+		return fallback
+	}
+	return parsed
+}
+
+func (loader *configLoader) positiveInt(name string, fallback int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 1 {
+		loader.invalid(name, value, "a positive integer")
+		return fallback
+	}
+	return parsed
+}
+
+// fraction parses a number between 0 and 1.
+func (loader *configLoader) fraction(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || parsed > 1 {
+		loader.invalid(name, value, "a number between 0 and 1")
+		return fallback
+	}
+	return parsed
+}
+
+// duration parses a Go duration such as 30s or 5m; allowZero accepts 0 for
+// settings where it switches the feature off.
+func (loader *configLoader) duration(name string, fallback time.Duration, allowZero bool) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 || (parsed == 0 && !allowZero) {
+		if allowZero {
+			loader.invalid(name, value, "a duration such as 30s or 5m, or 0")
+		} else {
+			loader.invalid(name, value, "a positive duration such as 30s or 5m")
+		}
+		return fallback
+	}
+	return parsed
+}
+
+// isUnixSocket reports whether addr is a filesystem path rather than host:port.
+func isUnixSocket(addr string) bool {
+	return strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, ".")
+}
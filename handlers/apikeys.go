@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// apiKeyPrefix starts every key so leaked keys are easy to recognise.
+const apiKeyPrefix = "rk_"
+
+type APIKeysHandler struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+func NewAPIKeysHandler(ctx context.Context, collection *mongo.Collection) *APIKeysHandler {
+	return &APIKeysHandler{
+		collection: collection,
+		ctx:        ctx,
+	}
+}
+
+// Authenticate lets requests carrying an X-API-Key header in as the service
+// the key belongs to, in the organization it was created in, and hands every
+// other request to next. The read scope allows GET requests, write the others
+// and admin grants the admin role.
+func (handler *APIKeysHandler) Authenticate(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			next(c)
+			return
+		}
+		if databaseUnavailable(c) {
+			return
+		}
+
+		var apiKey models.APIKey
+		err := handler.collection.FindOne(c.Request.Context(), bson.M{
+			"hash":      hashAPIKey(key),
+			"revokedAt": bson.M{"$exists": false},
+		}).Decode(&apiKey)
+		if err == mongo.ErrNoDocuments {
+			respondError(c, http.StatusUnauthorized, "invalid_api_key", "Invalid API key")
+			return
+		}
+		if err != nil {
+			respondDBError(c, err)
+			c.Abort()
+			return
+		}
+
+		scope := "write"
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = "read"
+		}
+		roles := []string{}
+		allowed := false
+		for _, granted := range apiKey.Scopes {
+			if granted == scope || granted == "admin" {
+				allowed = true
+			}
+			if granted == "admin" {
+				roles = append(roles, "admin")
+			}
+		}
+		if !allowed {
+			respondError(c, http.StatusForbidden, "forbidden", "API key lacks the "+scope+" scope")
+			return
+		}
+
+		setCurrentUser(c, AuthUser{Username: "service:" + apiKey.Name, Roles: roles, OrgID: apiKey.OrgID})
+		c.Set("apiKeyId", apiKey.ID.Hex())
+		c.Set("rateLimit", apiKey.RateLimit)
+		c.Next()
+	}
+}
+
+// swagger:operation POST /apikeys apikeys createAPIKey
+// Create an API key, admins only
+//
+// The key acts in the organization it is created in. It is only returned in
+// this response, the API keeps nothing but its hash. Keys get the read scope when none is given.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: Key to create
+//     required: true
+//     schema: {$ref: '#/definitions/APIKey'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'201':
+//	    description: The new key
+//	'400':
+//	    description: Invalid input
+//	'403':
+//	    description: Not an admin
+func (handler *APIKeysHandler) CreateAPIKeyHandler(c *gin.Context) {
+	var apiKey models.APIKey
+	if !bindJSON(c, &apiKey) {
+		return
+	}
+	if len(apiKey.Scopes) == 0 {
+		apiKey.Scopes = []string{"read"}
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	key := apiKeyPrefix + token
+	apiKey.ID = primitive.NewObjectID()
+	apiKey.Hash = hashAPIKey(key)
+	apiKey.Prefix = key[:len(apiKeyPrefix)+6]
+	user, _ := CurrentUser(c)
+	apiKey.OrgID = orgOf(c)
+	apiKey.CreatedBy = user.Username
+	apiKey.CreatedAt = time.Now()
+	apiKey.RevokedAt = nil
+	if _, err := handler.collection.InsertOne(c.Request.Context(), apiKey); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": key, "apiKey": apiKey})
+}
+
+// swagger:operation DELETE /apikeys/{id} apikeys revokeAPIKey
+// Revoke an API key, admins only
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the key
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Key revoked
+//	'400':
+//	    description: Invalid key ID
+//	'403':
+//	    description: Not an admin
+//	'404':
+//	    description: Key not found or already revoked
+func (handler *APIKeysHandler) RevokeAPIKeyHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	result, err := handler.collection.UpdateOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"revokedAt": bson.M{"$exists": false},
+	}), bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "API key not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key has been revoked"})
+}
+
+// hashAPIKey hashes keys for storage. Keys are long random strings, so a fast
+// unsalted hash is enough and keeps the lookup a single indexed query.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+type AuditHandler struct {
+	collection *mongo.Collection
+	ctx        context.Context
+	maxLimit   int64
+}
+
+func NewAuditHandler(ctx context.Context, collection *mongo.Collection, maxLimit int64) *AuditHandler {
+	return &AuditHandler{
+		collection: collection,
+		ctx:        ctx,
+		maxLimit:   maxLimit,
+	}
+}
+
+// audit records a write on a recipe in the audit collection and notifies the
+// webhooks. It is best effort: the insert runs in the background and failures
+// are only logged, so auditing never slows down or fails the request.
+func (handler *RecipesHandler) audit(c *gin.Context, action string, recipeId primitive.ObjectID, snapshot map[string]interface{}) {
+	user, _ := CurrentUser(c)
+	entry := models.AuditEntry{
+		Actor:     user.Username,
+		Action:    action,
+		RecipeID:  recipeId,
+		OrgID:     orgOf(c),
+		Timestamp: time.Now(),
+		Snapshot:  snapshot,
+	}
+	collection := handler.collection.Database().Collection("audit")
+	logger := loggerFrom(c.Request.Context())
+	go func() {
+		if _, err := collection.InsertOne(handler.ctx, entry); err != nil {
+			logger.Error("Failed to audit", "action", action, "recipeId", recipeId.Hex(), "error", err)
+		}
+	}()
+	handler.notify(c, action, recipeId, snapshot)
+}
+
+// recipeSnapshot keeps the user editable fields of a recipe for the audit log.
+func recipeSnapshot(recipe models.Recipe) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         recipe.Name,
+		"tags":         recipe.Tags,
+		"cuisine":      recipe.Cuisine,
+		"servings":     recipe.Servings,
+		"difficulty":   recipe.Difficulty,
+		"prepTime":     recipe.PrepTime,
+		"cookTime":     recipe.CookTime,
+		"ingredients":  recipe.Ingredients,
+		"instructions": recipe.Instructions,
+	}
+}
+
+// swagger:operation GET /audit audit listAudit
+// List the audit entries of the organization, newest first, admins only
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: user
+//     in: query
+//     description: only entries made by this user
+//     required: false
+//     type: string
+//   - name: from
+//     in: query
+//     description: only entries at or after this RFC 3339 time
+//     required: false
+//     type: string
+//     format: date-time
+//   - name: to
+//     in: query
+//     description: only entries before this RFC 3339 time
+//     required: false
+//     type: string
+//     format: date-time
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: limit
+//     in: query
+//     description: number of entries per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid query parameters
+//	'403':
+//	    description: Not an admin
+func (handler *AuditHandler) ListAuditHandler(c *gin.Context) {
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	filter := inOrg(c, bson.M{})
+	if user := c.Query("user"); user != "" {
+		filter["actor"] = user
+	}
+	timestamp := bson.M{}
+	for param, operator := range map[string]string{"from": "$gte", "to": "$lt"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "bad_request", param+" must be an RFC 3339 time")
+			return
+		}
+		timestamp[operator] = t
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	total, err := handler.collection.CountDocuments(c.Request.Context(), filter)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cur, err := handler.collection.Find(c.Request.Context(), filter, opts)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(c.Request.Context())
+
+	entries := make([]models.AuditEntry, 0)
+	if err := cur.All(c.Request.Context(), &entries); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AuditList{
+		Data:       entries,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: (total + limit - 1) / limit,
+	})
+}
@@ -1,37 +1,70 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
 	"github.com/rs/xid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/net/context"
 
 	"github.com/Jovdza012/gin_chapter_2/models"
 )
 
 type AuthHandler struct {
-	collection *mongo.Collection
-	ctx        context.Context
+	collection      *mongo.Collection
+	ctx             context.Context
+	redisClient     *redis.Client
+	jwtSecret       []byte
+	maxFailedLogins int64
+	lockoutDuration time.Duration
+	mailer          Mailer
+	publicURL       string
+	passwordPolicy  PasswordPolicy
 }
 
-func NewAuthHandler(ctx context.Context, collection *mongo.Collection) *AuthHandler {
+func NewAuthHandler(ctx context.Context, collection *mongo.Collection, redisClient *redis.Client, jwtSecret string, maxFailedLogins int64, lockoutDuration time.Duration, mailer Mailer, publicURL string, passwordPolicy PasswordPolicy) *AuthHandler {
 	return &AuthHandler{
-		collection: collection,
-		ctx:        ctx,
+		collection:      collection,
+		ctx:             ctx,
+		redisClient:     redisClient,
+		jwtSecret:       []byte(jwtSecret),
+		maxFailedLogins: maxFailedLogins,
+		lockoutDuration: lockoutDuration,
+		mailer:          mailer,
+		publicURL:       strings.TrimSuffix(publicURL, "/"),
+		passwordPolicy:  passwordPolicy,
 	}
 }
 
+const (
+	tokenTTL      = 10 * time.Minute
+	refreshWindow = 5 * time.Minute
+	refreshGrace  = 30 * time.Second
+
+	verificationTTL = 24 * time.Hour
+)
+
 type Claims struct {
-	Username string `json:"username"`
+	UserID   string   `json:"uid,omitempty"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	OrgID    string   `json:"org,omitempty"`
 	jwt.StandardClaims
 }
 type JWTOutput struct {
@@ -44,161 +77,792 @@ func (handler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 		session := sessions.Default(c)
 		sessionToken := session.Get("token")
 		if sessionToken == nil {
-			c.JSON(http.StatusForbidden, gin.H{
-				"message": "Not logged",
-			})
+			respondError(c, http.StatusForbidden, "forbidden", "Not logged")
+			c.Abort()
+			return
+		}
+		user := AuthUser{}
+		user.ID, _ = session.Get("userId").(string)
+		user.Username, _ = session.Get("username").(string)
+		user.Roles, _ = session.Get("roles").([]string)
+		user.OrgID, _ = session.Get("orgId").(string)
+		issuedAt, _ := session.Get("issuedAt").(int64)
+		signedOut, err := handler.signedOutSince(user.Username, issuedAt)
+		if err != nil {
+			respondDBError(c, err)
+			c.Abort()
+			return
+		}
+		if signedOut {
+			session.Clear()
+			session.Save()
+			respondError(c, http.StatusForbidden, "session_revoked", "Session has been signed out")
+			return
+		}
+		setCurrentUser(c, user)
+		c.Next()
+	}
+}
+
+// bearerToken reads the token of an "Authorization: Bearer <token>" header,
+// matching the scheme regardless of case. It answers 401 when the header is
+// missing, names another scheme or holds a bare token.
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		respondError(c, http.StatusUnauthorized, "missing_token", "Missing bearer token")
+		return "", false
+	}
+	return token, true
+}
+
+// JWTMiddleware authenticates requests carrying an "Authorization: Bearer <token>"
+// header signed with the configured secret. Missing, malformed or expired tokens get a 401.
+func (handler *AuthHandler) JWTMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenValue, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		claims := &Claims{}
+		tkn, err := jwt.ParseWithClaims(tokenValue, claims, handler.keyFunc)
+		if err != nil || tkn == nil || !tkn.Valid {
+			respondError(c, http.StatusUnauthorized, "invalid_token", "Invalid token")
+			return
+		}
+		revoked, err := handler.isRevoked(claims)
+		if err != nil {
+			respondDBError(c, err)
 			c.Abort()
+			return
+		}
+		if revoked {
+			respondError(c, http.StatusUnauthorized, "token_revoked", "Token has been revoked")
+			return
+		}
+
+		setCurrentUser(c, AuthUser{ID: claims.UserID, Username: claims.Username, Roles: claims.Roles, OrgID: claims.OrgID})
+		c.Next()
+	}
+}
+
+// RequireRole only lets through users holding role. It must be chained after
+// AuthMiddleware or JWTMiddleware, which resolve the current user.
+func (handler *AuthHandler) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasRole(c, role) {
+			respondError(c, http.StatusForbidden, "forbidden", "Insufficient permissions")
+			return
 		}
 		c.Next()
 	}
 }
 
-// func (handler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
-// 	return func(c *gin.Context) {
-// 		tokenValue := c.GetHeader("Authorization")
-// 		claims := &Claims{}
-// 		tkn, err := jwt.ParseWithClaims(tokenValue, claims,
-// 			func(token *jwt.Token) (interface{}, error) {
-// 				return []byte(os.Getenv("JWT_SECRET")), nil
-// 			})
-// 		if err != nil {
-// 			c.AbortWithStatus(http.StatusUnauthorized)
-// 		}
-// 		if tkn == nil || !tkn.Valid {
-// 			c.AbortWithStatus(http.StatusUnauthorized)
-// 		}
-// 		c.Next()
-// 	}
-// }
+// keyFunc returns the HMAC secret used to verify tokens and refuses any other
+// signing method so a token can't downgrade to "none" or switch to RSA.
+func (handler *AuthHandler) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return handler.jwtSecret, nil
+}
 
+// swagger:operation POST /refresh auth refreshToken
+// Exchange a token in the last 5 minutes of its life for a new one
+//
+// RefreshHandler trades a token that is about to expire for a new one. Tokens
+// are only refreshed during the last refreshWindow of their life, and expired
+// tokens are still accepted for refreshGrace to absorb clock skew.
+// ---
+// produces:
+// - application/json
+// responses:
+//
+//	'200':
+//	    description: The new token
+//	'400':
+//	    description: Token is not close enough to expiry
+//	'401':
+//	    description: Invalid, expired or revoked token
 func (handler *AuthHandler) RefreshHandler(c *gin.Context) {
-	tokenValue := c.GetHeader("Authorization")
+	tokenValue, ok := bearerToken(c)
+	if !ok {
+		return
+	}
 	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(tokenValue, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
+	tkn, err := jwt.ParseWithClaims(tokenValue, claims, handler.keyFunc)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		// only expiry is tolerated here, the signature must still be valid
+		validationErr, ok := err.(*jwt.ValidationError)
+		if !ok || validationErr.Errors != jwt.ValidationErrorExpired {
+			respondError(c, http.StatusUnauthorized, "invalid_token", "Invalid token")
+			return
+		}
+	} else if tkn == nil || !tkn.Valid {
+		respondError(c, http.StatusUnauthorized, "invalid_token", "Invalid token")
 		return
 	}
 
-	if tkn == nil || !tkn.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	revoked, err := handler.isRevoked(claims)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if revoked {
+		respondError(c, http.StatusUnauthorized, "token_revoked", "Token has been revoked")
 		return
 	}
 
-	if time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > 30*time.Second {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is not expired yet"})
+	remaining := time.Until(time.Unix(claims.ExpiresAt, 0))
+	if remaining < -refreshGrace {
+		respondError(c, http.StatusUnauthorized, "token_expired", "Token has expired")
+		return
+	}
+	if remaining > refreshWindow {
+		respondError(c, http.StatusBadRequest, "bad_request", "Token is not expired yet")
 		return
 	}
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims.ExpiresAt = expirationTime.Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(os.Getenv("JWT_SECRET"))
 
+	// the old token must not outlive the refresh
+	if err := handler.revokeToken(claims); err != nil {
+		respondDBError(c, err)
+		return
+	}
+	jwtOutput, err := handler.issueToken(claims)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
+	c.JSON(http.StatusOK, jwtOutput)
+}
 
-	jwtOutput := JWTOutput{
+// issueToken signs claims with a fresh expiry and a new token id (jti), which
+// the denylist uses to revoke this token alone.
+func (handler *AuthHandler) issueToken(claims *Claims) (JWTOutput, error) {
+	expirationTime := time.Now().Add(tokenTTL)
+	claims.ExpiresAt = expirationTime.Unix()
+	claims.IssuedAt = time.Now().Unix()
+	claims.Id = xid.New().String()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString(handler.jwtSecret)
+	if err != nil {
+		return JWTOutput{}, err
+	}
+	return JWTOutput{
 		Token:   tokenString,
 		Expires: expirationTime,
-	}
-	c.JSON(http.StatusOK, jwtOutput)
+	}, nil
 }
 
+// swagger:operation POST /signin auth signIn
+// Sign in, starting a session or returning a JWT depending on AUTH_MODE
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: Account credentials
+//     required: true
+//     schema: {$ref: '#/definitions/Credentials'}
+//
+// responses:
+//
+//	'200':
+//	    description: Signed in
+//	'400':
+//	    description: Invalid input
+//	'401':
+//	    description: Invalid username or password
+//	'403':
+//	    description: Email address not verified
+//	'423':
+//	    description: Account is locked after too many failed attempts
+//	'429':
+//	    $ref: '#/responses/tooManyRequests'
 func (handler *AuthHandler) SignInHandler(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
 		return
 	}
 
-	// Hash the provided password using SHA-256
-	hashedPassword := sha256.Sum256([]byte(user.Password))
-	hashedPasswordStr := hex.EncodeToString(hashedPassword[:])
-
-	// Find the user by username and compare hashed passwords
-	cur := handler.collection.FindOne(handler.ctx, bson.M{
-		"username": user.Username,
-		"password": hashedPasswordStr, // compare the hashed password
-	})
-	if cur.Err() != nil {
-		if cur.Err() == mongo.ErrNoDocuments {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
-			return
-		}
-		// Handle other possible errors from MongoDB
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	stored, status, err := handler.checkCredentials(c.Request.Context(), user)
+	if err != nil {
+		respondError(c, status, errorCode(status), err.Error())
 		return
 	}
 
 	sessionToken := xid.New().String()
 	session := sessions.Default(c)
+	session.Set("userId", stored.ID.Hex())
 	session.Set("username", user.Username)
+	session.Set("roles", stored.Roles)
+	session.Set("orgId", orgOrDefault(stored.OrgID))
 	session.Set("token", sessionToken)
+	session.Set("issuedAt", time.Now().Unix())
 	session.Save()
 	c.JSON(http.StatusOK, gin.H{"message": "User signed in"})
 }
 
+// swagger:operation POST /signout auth signOut
+// End the current session and revoke the bearer token, if one is sent
+// ---
+// produces:
+// - application/json
+// responses:
+//
+//	'200':
+//	    description: Signed out
+//	'401':
+//	    description: Authorization header without a bearer token
 func (handler *AuthHandler) SignOutHandler(c *gin.Context) {
 	session := sessions.Default(c)
 	session.Clear()
 	session.Save()
+
+	if c.GetHeader("Authorization") != "" {
+		tokenValue, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+		claims := &Claims{}
+		tkn, err := jwt.ParseWithClaims(tokenValue, claims, handler.keyFunc)
+		if err == nil && tkn.Valid {
+			if err := handler.revokeToken(claims); err != nil {
+				respondDBError(c, err)
+				return
+			}
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Signed out..."})
 }
 
-// func (handler *AuthHandler) SignInHandler(c *gin.Context) {
-// 	var user models.User
-// 	if err := c.ShouldBindJSON(&user); err != nil {
-// 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-// 		return
-// 	}
-
-// 	// Hash the provided password using SHA-256
-// 	hashedPassword := sha256.Sum256([]byte(user.Password))
-// 	hashedPasswordStr := hex.EncodeToString(hashedPassword[:])
-
-// 	// Find the user by username and compare hashed passwords
-// 	cur := handler.collection.FindOne(handler.ctx, bson.M{
-// 		"username": user.Username,
-// 		"password": hashedPasswordStr, // compare the hashed password
-// 	})
-// 	if cur.Err() != nil {
-// 		if cur.Err() == mongo.ErrNoDocuments {
-// 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
-// 			return
-// 		}
-// 		// Handle other possible errors from MongoDB
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-// 		return
-// 	}
-
-// 	// Create JWT claims
-// 	expirationTime := time.Now().Add(10 * time.Minute)
-// 	claims := &Claims{
-// 		Username: user.Username,
-// 		StandardClaims: jwt.StandardClaims{
-// 			ExpiresAt: expirationTime.Unix(),
-// 		},
-// 	}
-
-// 	// Create JWT token
-// 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-// 	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-// 	if err != nil {
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-// 		return
-// 	}
-
-// 	// Return the token and expiration time
-// 	jwtOutput := JWTOutput{
-// 		Token:   tokenString,
-// 		Expires: expirationTime,
-// 	}
-
-// 	c.JSON(http.StatusOK, jwtOutput)
-// }
+// revokeToken puts the token on the Redis denylist until it can no longer be
+// used or refreshed anyway, so the denylist never grows past the live tokens.
+func (handler *AuthHandler) revokeToken(claims *Claims) error {
+	remaining := time.Until(time.Unix(claims.ExpiresAt, 0)) + refreshGrace
+	if claims.Id == "" || remaining <= 0 {
+		return nil
+	}
+	return handler.redisClient.Set("jwt:denylist:"+claims.Id, 1, remaining).Err()
+}
+
+// isRevoked reports whether the token was signed out or refreshed, or issued
+// before its user signed out everywhere. Tokens issued before token ids were
+// introduced can only be revoked the latter way.
+func (handler *AuthHandler) isRevoked(claims *Claims) (bool, error) {
+	signedOut, err := handler.signedOutSince(claims.Username, claims.IssuedAt)
+	if err != nil || signedOut || claims.Id == "" {
+		return signedOut, err
+	}
+	count, err := handler.redisClient.Exists("jwt:denylist:" + claims.Id).Result()
+	return count > 0, err
+}
+
+// signOutEverywhere ends the sessions and tokens of username issued until
+// now. Only the time is kept, one key per user, which the middlewares compare
+// against the time each session or token was issued at.
+func (handler *AuthHandler) signOutEverywhere(username string) error {
+	return handler.redisClient.Set("signout:"+username, time.Now().Unix(), 0).Err()
+}
+
+// signedOutSince reports whether username signed out everywhere after
+// issuedAt, a Unix time. Sessions and tokens issued before the time was
+// recorded in them count as issued at 0.
+func (handler *AuthHandler) signedOutSince(username string, issuedAt int64) (bool, error) {
+	signedOutAt, err := handler.redisClient.Get("signout:" + username).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil && issuedAt < signedOutAt, err
+}
+
+// JWTSignInHandler checks the credentials like SignInHandler but, instead of
+// starting a session, returns a signed HS256 token in the response body.
+func (handler *AuthHandler) JWTSignInHandler(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindJSON(&user); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	stored, status, err := handler.checkCredentials(c.Request.Context(), user)
+	if err != nil {
+		respondError(c, status, errorCode(status), err.Error())
+		return
+	}
+
+	jwtOutput, err := handler.issueToken(&Claims{UserID: stored.ID.Hex(), Username: user.Username, Roles: stored.Roles, OrgID: orgOrDefault(stored.OrgID)})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, jwtOutput)
+}
+
+// swagger:operation POST /signup auth signUp
+// Create an account
+//
+// SignUpHandler stores only the bcrypt hash of the password. The account
+// can't sign in until the link emailed to the given address is followed.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: New account
+//     required: true
+//     schema: {$ref: '#/definitions/SignUp'}
+//
+// responses:
+//
+//	'201':
+//	    description: Account created, verification email sent
+//	'400':
+//	    description: Invalid input, missing email or password not following the policy
+//	'409':
+//	    description: Username is already taken
+//	'429':
+//	    $ref: '#/responses/tooManyRequests'
+func (handler *AuthHandler) SignUpHandler(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindJSON(&user); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if user.Email == "" {
+		respondError(c, http.StatusBadRequest, "bad_request", "Email is required")
+		return
+	}
+
+	if !handler.checkPassword(c, "password", user.Password) {
+		return
+	}
+
+	count, err := handler.collection.CountDocuments(c.Request.Context(), bson.M{
+		"username": user.Username,
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	if count > 0 {
+		respondError(c, http.StatusConflict, "username_taken", "Username is already taken")
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	_, err = handler.collection.InsertOne(c.Request.Context(), bson.M{
+		"username":   user.Username,
+		"password":   string(hashedPassword),
+		"email":      user.Email,
+		"unverified": true,
+		"orgId":      DefaultOrg,
+		"createdAt":  time.Now(),
+	})
+	if err != nil {
+		// a concurrent sign up can still win the race, the unique index catches it
+		if mongo.IsDuplicateKeyError(err) {
+			respondError(c, http.StatusConflict, "username_taken", "Username is already taken")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "internal_error", "Error while creating the user")
+		return
+	}
+
+	// the account exists either way, a lost email only delays the sign in
+	if err := handler.sendVerification(user); err != nil {
+		loggerFrom(c.Request.Context()).Error("Failed to send verification email", "error", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"username": user.Username, "email": user.Email})
+}
+
+// sendVerification stores a single-use token for the user in Redis and
+// emails the link that redeems it.
+func (handler *AuthHandler) sendVerification(user models.User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err := handler.redisClient.Set("verify:"+token, user.Username, verificationTTL).Err(); err != nil {
+		return err
+	}
+	link := handler.publicURL + "/verify?token=" + token
+	return handler.mailer.Send(user.Email, "Confirm your email address",
+		fmt.Sprintf("Hi %s,\n\nfollow this link within %s to confirm your email address:\n%s\n", user.Username, verificationTTL, link))
+}
+
+// swagger:operation GET /verify auth verifyEmail
+// Confirm the email address of an account
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: token
+//     in: query
+//     description: token from the verification email
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	    description: Email verified, the account can sign in
+//	'400':
+//	    description: Invalid or expired token
+//	'429':
+//	    $ref: '#/responses/tooManyRequests'
+func (handler *AuthHandler) VerifyEmailHandler(c *gin.Context) {
+	key := "verify:" + c.Query("token")
+	username, err := handler.redisClient.Get(key).Result()
+	if err == redis.Nil || c.Query("token") == "" {
+		respondError(c, http.StatusBadRequest, "bad_request", "Invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	_, err = handler.collection.UpdateOne(c.Request.Context(), bson.M{
+		"username": username,
+	}, bson.M{"$unset": bson.M{"unverified": ""}})
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	handler.redisClient.Del(key)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// randomToken returns 32 random bytes, hex encoded.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// swagger:operation GET /me auth getProfile
+// Return the profile of the signed in user
+// ---
+// produces:
+// - application/json
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The profile
+//	'401':
+//	    description: Not signed in
+func (handler *AuthHandler) ProfileHandler(c *gin.Context) {
+	current, ok := CurrentUser(c)
+	username := current.Username
+	if !ok || username == "" {
+		respondError(c, http.StatusUnauthorized, "unauthorized", "Not signed in")
+		return
+	}
+
+	var user struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Email     string             `bson:"email"`
+		Roles     []string           `bson:"roles"`
+		OrgID     string             `bson:"orgId"`
+		CreatedAt *time.Time         `bson:"createdAt"`
+	}
+	err := handler.collection.FindOne(c.Request.Context(), bson.M{
+		"username": username,
+	}, options.FindOne().SetProjection(bson.M{"email": 1, "roles": 1, "orgId": 1, "createdAt": 1})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusUnauthorized, "unauthorized", "Account no longer exists")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	// accounts created before createdAt was stored fall back to the time
+	// embedded in their ObjectID
+	createdAt := user.ID.Timestamp()
+	if user.CreatedAt != nil {
+		createdAt = *user.CreatedAt
+	}
+	roles := user.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	c.JSON(http.StatusOK, models.Profile{
+		Username:  username,
+		Email:     user.Email,
+		Roles:     roles,
+		OrgID:     orgOrDefault(user.OrgID),
+		CreatedAt: createdAt.UTC(),
+	})
+}
+
+// swagger:operation PUT /users/{username}/roles users updateUserRoles
+// Replace the roles of a user, admins only
+//
+// UpdateRolesHandler replaces the roles of a user. The new roles only apply
+// once the user signs in again. Admins only manage the users of their
+// organization, and only super admins grant or take the superadmin role.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: username
+//     in: path
+//     description: user to update
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     description: New roles
+//     required: true
+//     schema: {$ref: '#/definitions/Roles'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The updated roles
+//	'403':
+//	    description: Not an admin, or granting superadmin without being one
+//	'404':
+//	    description: User not found
+func (handler *AuthHandler) UpdateRolesHandler(c *gin.Context) {
+	var body struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if body.Roles == nil {
+		body.Roles = []string{}
+	}
+
+	superAdmin := hasRole(c, superAdminRole)
+	for _, role := range body.Roles {
+		if role == superAdminRole && !superAdmin {
+			respondError(c, http.StatusForbidden, "forbidden", "Only super admins can grant the superadmin role")
+			return
+		}
+	}
+
+	username := c.Param("username")
+	filter := inOrg(c, bson.M{"username": username})
+	if !superAdmin {
+		filter["roles"] = bson.M{"$ne": superAdminRole}
+	}
+	result, err := handler.collection.UpdateOne(c.Request.Context(), filter, bson.M{"$set": bson.M{"roles": body.Roles}})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	if result.MatchedCount == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "roles": body.Roles})
+}
+
+// swagger:operation PUT /users/{username}/org users updateUserOrg
+// Move a user to another organization, super admins only
+//
+// UpdateOrgHandler moves a user, leaving their recipes in the organization
+// they were created in. Like roles, the organization only changes once the
+// user signs in again.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: username
+//     in: path
+//     description: user to move
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     description: New organization
+//     required: true
+//     schema: {$ref: '#/definitions/UserOrg'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The new organization
+//	'400':
+//	    description: Invalid organization
+//	'403':
+//	    description: Not a super admin
+//	'404':
+//	    description: User not found
+func (handler *AuthHandler) UpdateOrgHandler(c *gin.Context) {
+	var body struct {
+		OrgID string `json:"orgId" binding:"required,max=64"`
+	}
+	if !bindJSON(c, &body) {
+		return
+	}
+	if body.OrgID = strings.TrimSpace(body.OrgID); body.OrgID == "" {
+		respondError(c, http.StatusBadRequest, "bad_request", "orgId must not be blank")
+		return
+	}
+
+	username := c.Param("username")
+	result, err := handler.collection.UpdateOne(c.Request.Context(), bson.M{
+		"username": username,
+	}, bson.M{"$set": bson.M{"orgId": body.OrgID}})
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "orgId": body.OrgID})
+}
+
+// checkCredentials looks the user up by username and verifies the password
+// against the stored bcrypt hash, returning the stored user. After too many
+// consecutive failures the account is locked for lockoutDuration. The returned
+// status is the one the handler should answer with on error.
+func (handler *AuthHandler) checkCredentials(ctx context.Context, user models.User) (models.User, int, error) {
+	failuresKey := "login:failures:" + user.Username
+	failures, err := handler.redisClient.Get(failuresKey).Int64()
+	if err != nil && err != redis.Nil {
+		loggerFrom(ctx).Warn("Failed to read login failures", "error", err)
+	}
+	if failures >= handler.maxFailedLogins {
+		return models.User{}, http.StatusLocked, errors.New("Account is locked, try again later")
+	}
+
+	stored, status, err := handler.verifyPassword(ctx, user)
+	if err != nil {
+		if status == http.StatusUnauthorized {
+			handler.recordFailedLogin(ctx, failuresKey)
+		}
+		return stored, status, err
+	}
+
+	handler.redisClient.Del(failuresKey)
+	if stored.Unverified {
+		return stored, http.StatusForbidden, errors.New("Email address not verified, follow the link sent to it first")
+	}
+	return stored, http.StatusOK, nil
+}
+
+// recordFailedLogin counts a failed attempt. The counter expires on its own
+// lockoutDuration after the latest failure, which also ends a lockout.
+func (handler *AuthHandler) recordFailedLogin(ctx context.Context, failuresKey string) {
+	pipe := handler.redisClient.TxPipeline()
+	pipe.Incr(failuresKey)
+	pipe.Expire(failuresKey, handler.lockoutDuration)
+	if _, err := pipe.Exec(); err != nil {
+		loggerFrom(ctx).Warn("Failed to record login failure", "error", err)
+	}
+}
+
+// verifyPassword checks the password against the stored user, migrating
+// legacy hashes to bcrypt on success.
+func (handler *AuthHandler) verifyPassword(ctx context.Context, user models.User) (models.User, int, error) {
+	var stored models.User
+	err := handler.collection.FindOne(ctx, bson.M{
+		"username": user.Username,
+	}).Decode(&stored)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return stored, http.StatusUnauthorized, errors.New("Invalid username or password")
+		}
+		return stored, http.StatusInternalServerError, errors.New("Internal server error")
+	}
+
+	if !isBcryptHash(stored.Password) {
+		if !legacyPasswordMatches(stored.Password, user.Password) {
+			return stored, http.StatusUnauthorized, errors.New("Invalid username or password")
+		}
+		handler.rehashPassword(ctx, user)
+		return stored, http.StatusOK, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte(user.Password)); err != nil {
+		return stored, http.StatusUnauthorized, errors.New("Invalid username or password")
+	}
+	return stored, http.StatusOK, nil
+}
+
+// hasRole reports whether the authenticated user has the given role. Super
+// admins hold the admin role too, of whichever organization they act in.
+func hasRole(c *gin.Context, role string) bool {
+	user, _ := CurrentUser(c)
+	for _, r := range user.Roles {
+		if r == role || r == superAdminRole && role == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// isBcryptHash reports whether a stored password is already a bcrypt hash.
+func isBcryptHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
+// legacyPasswordMatches verifies passwords stored before bcrypt was introduced,
+// either as a hex SHA-256 digest or as plaintext.
+func legacyPasswordMatches(stored, password string) bool {
+	hashedPassword := sha256.Sum256([]byte(password))
+	hashedPasswordStr := hex.EncodeToString(hashedPassword[:])
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(hashedPasswordStr)) == 1 ||
+		subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// rehashPassword migrates a legacy password to bcrypt after a successful login.
+// Failures are only logged, the user will be migrated on the next login instead.
+func (handler *AuthHandler) rehashPassword(ctx context.Context, user models.User) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to hash password", "username", user.Username, "error", err)
+		return
+	}
+	_, err = handler.collection.UpdateOne(ctx, bson.M{
+		"username": user.Username,
+	}, bson.M{"$set": bson.M{"password": string(hashedPassword)}})
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to migrate password", "username", user.Username, "error", err)
+		return
+	}
+	loggerFrom(ctx).Info("Migrated password to bcrypt", "username", user.Username)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testAuthHandler returns an AuthHandler locking accounts after 3 failed
+// sign ins, with the default password policy.
+func testAuthHandler(collection *mongo.Collection, redisClient *redis.Client) *AuthHandler {
+	return NewAuthHandler(context.Background(), collection, redisClient, "test-secret", 3, time.Minute, LogMailer{}, "http://localhost",
+		NewPasswordPolicy(8, false, false, false, false, true))
+}
+
+// bearer signs a token for username holding roles.
+func bearer(t *testing.T, handler *AuthHandler, username string, roles ...string) string {
+	t.Helper()
+	token, err := handler.issueToken(&Claims{Username: username, Roles: roles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token.Token
+}
+
+func TestRequireRole(t *testing.T) {
+	redisClient, _ := testRedis(t)
+	handler := testAuthHandler(nil, redisClient)
+	router := gin.New()
+	router.Use(handler.JWTMiddleware())
+	router.DELETE("/recipes/:id/permanent", handler.RequireRole("admin"), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		roles  []string
+		status int
+	}{
+		{"user without roles", nil, http.StatusForbidden},
+		{"user with another role", []string{"editor"}, http.StatusForbidden},
+		{"admin", []string{"admin"}, http.StatusNoContent},
+		{"super admin", []string{"superadmin"}, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		w := serve(router, http.MethodDelete, "/recipes/1/permanent", "", "Authorization", bearer(t, handler, "cook", test.roles...))
+		if w.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+
+	if w := serve(router, http.MethodDelete, "/recipes/1/permanent", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got %d, want 401", w.Code)
+	}
+}
+
+func TestBearerScheme(t *testing.T) {
+	redisClient, _ := testRedis(t)
+	handler := testAuthHandler(nil, redisClient)
+	router := gin.New()
+	router.GET("/me", handler.JWTMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.POST("/refresh", handler.RefreshHandler)
+	token := strings.TrimPrefix(bearer(t, handler, "cook"), "Bearer ")
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"bearer token", "Bearer " + token, http.StatusNoContent},
+		{"lower case scheme", "bearer " + token, http.StatusNoContent},
+		{"upper case scheme", "BEARER " + token, http.StatusNoContent},
+		{"bare token", token, http.StatusUnauthorized},
+		{"another scheme", "Basic " + token, http.StatusUnauthorized},
+		{"no token", "Bearer ", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		if w := serve(router, http.MethodGet, "/me", "", "Authorization", test.header); w.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+
+	if w := serve(router, http.MethodPost, "/refresh", "", "Authorization", token); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh with a bare token: got %d, want 401", w.Code)
+	}
+}
+
+func TestSignInLockout(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, redisServer := testRedis(t)
+	handler := testAuthHandler(db.Collection("users"), redisClient)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if _, err := db.Collection("users").InsertOne(context.Background(), bson.M{"username": "cook", "password": string(hash)}); err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.POST("/signin", handler.JWTSignInHandler)
+	signIn := func(password string) int {
+		return serve(router, http.MethodPost, "/signin", `{"username": "cook", "password": "`+password+`"}`).Code
+	}
+
+	// a success in between starts the count over
+	signIn("wrong")
+	signIn("wrong")
+	if status := signIn("correct horse"); status != http.StatusOK {
+		t.Fatalf("sign in before the lockout: got %d, want 200", status)
+	}
+	for i := 0; i < 3; i++ {
+		if status := signIn("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got %d, want 401", i+1, status)
+		}
+	}
+	if status := signIn("correct horse"); status != http.StatusLocked {
+		t.Fatalf("locked account: got %d, want 423", status)
+	}
+	if ttl := redisServer.TTL("login:failures:cook"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("failure counter expires in %s, want within the lockout duration", ttl)
+	}
+
+	redisServer.FastForward(time.Minute)
+	if status := signIn("correct horse"); status != http.StatusOK {
+		t.Errorf("after the lockout: got %d, want 200", status)
+	}
+}
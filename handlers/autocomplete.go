@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultCompletions = 10
+	maxCompletions     = 20
+	maxPrefixLength    = 100
+)
+
+// Completion is a recipe name suggested for a prefix.
+type Completion struct {
+	ID   primitive.ObjectID `json:"id" bson:"_id"`
+	Name string             `json:"name" bson:"name"`
+}
+
+// swagger:operation GET /recipes/autocomplete recipes autocompleteRecipes
+// Returns the names of the most rated recipes starting with a prefix
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: prefix
+//     in: query
+//     description: start of the recipe name, ignoring case
+//     required: true
+//     type: string
+//   - name: limit
+//     in: query
+//     description: number of names (max 20)
+//     required: false
+//     type: integer
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid query parameters
+func (handler *RecipesHandler) AutocompleteHandler(c *gin.Context) {
+	prefix := strings.ToLower(strings.TrimSpace(c.Query("prefix")))
+	if prefix == "" || utf8.RuneCountInString(prefix) > maxPrefixLength {
+		respondError(c, http.StatusBadRequest, "bad_request", fmt.Sprintf("prefix must be between 1 and %d characters", maxPrefixLength))
+		return
+	}
+	limit := int64(defaultCompletions)
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxCompletions {
+			respondError(c, http.StatusBadRequest, "bad_request", fmt.Sprintf("limit must be between 1 and %d", maxCompletions))
+			return
+		}
+		limit = parsed
+	}
+
+	// prefixes repeat a lot while users type, so the names share the
+	// "recipes" hash with the list pages and are invalidated with them
+	cacheField := fmt.Sprintf("autocomplete:org=%s:limit=%d:prefix=%s", orgOf(c), limit, prefix)
+	if handler.cacheTTL > 0 {
+		val, err := handler.redisClient.HGet("recipes", cacheField).Result()
+		if err != nil && err != redis.Nil {
+			respondDBError(c, err)
+			return
+		}
+		if err == nil {
+			c.Header("X-Cache", "HIT")
+			c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(val))
+			return
+		}
+	}
+
+	// an anchored regex walks the name index instead of the collection
+	cur, err := handler.collection.Find(c.Request.Context(), inOrg(c, bson.M{
+		"name":      bson.M{"$regex": "^" + regexp.QuoteMeta(prefix), "$options": "i"},
+		"deletedAt": notDeleted,
+	}), options.Find().
+		SetProjection(bson.M{"name": 1}).
+		SetSort(bson.D{{Key: "ratingCount", Value: -1}, {Key: "avgRating", Value: -1}, {Key: "name", Value: 1}}).
+		SetLimit(limit))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	completions := make([]Completion, 0, limit)
+	if err := cur.All(c.Request.Context(), &completions); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	data, _ := json.Marshal(completions)
+	if handler.cacheTTL > 0 {
+		handler.cacheListVariant(cacheField, string(data))
+	}
+	c.Header("X-Cache", "MISS")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// ImportResult counts what an import did with the submitted recipes, or
+// would do on a dry run. Recipes identical to the stored copy count as
+// skipped, like those without an id; invalid and failed ones are listed in
+// Errors.
+type ImportResult struct {
+	DryRun   bool         `json:"dryRun,omitempty"`
+	Inserted int64        `json:"inserted"`
+	Updated  int64        `json:"updated"`
+	Skipped  int64        `json:"skipped"`
+	Failed   int64        `json:"failed"`
+	Errors   []BulkResult `json:"errors"`
+}
+
+// swagger:operation GET /recipes/export recipes exportRecipes
+// Export every recipe of the organization as a JSON array, admins only
+//
+// The recipes are streamed from a cursor, deleted ones included, so the
+// export can be fed back to POST /recipes/import as is.
+// ---
+// produces:
+// - application/json
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: All recipes
+//	'403':
+//	    description: Not an admin
+func (handler *RecipesHandler) ExportRecipesHandler(c *gin.Context) {
+	// the export streams for as long as it takes, past REQUEST_TIMEOUT
+	ctx := context.WithoutCancel(c.Request.Context())
+	cur, err := handler.collection.Find(ctx, inOrg(c, bson.M{}), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(ctx)
+
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="recipes-%s.json"`, time.Now().Format("20060102")))
+	c.Status(http.StatusOK)
+
+	// once streaming has started the status can't change anymore, so errors
+	// past this point only end the array early
+	encoder := json.NewEncoder(c.Writer)
+	c.Writer.WriteString("[")
+	for count := 0; cur.Next(ctx); count++ {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			loggerFrom(c.Request.Context()).Error("Failed to decode recipe during export", "error", err)
+			break
+		}
+		if count > 0 {
+			c.Writer.WriteString(",")
+		}
+		if err := encoder.Encode(recipe); err != nil {
+			loggerFrom(c.Request.Context()).Warn("Export interrupted", "error", err)
+			return
+		}
+		if count%maxBulkSize == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		loggerFrom(c.Request.Context()).Warn("Export interrupted", "error", err)
+	}
+	c.Writer.WriteString("]\n")
+}
+
+// swagger:operation POST /recipes/import recipes importRecipes
+// Import recipes from an export, admins only
+//
+// Recipes are upserted by id in batches, so importing the same export twice
+// changes nothing. They are imported into the organization of the request
+// whatever their orgId, and those whose id is taken by a recipe of another
+// organization fail. The body is decoded as a stream. With dryRun=true the
+// recipes go through the same validation and matching but nothing is
+// written, only the counts the import would give are returned.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: dryRun
+//     in: query
+//     description: only report what the import would do
+//     required: false
+//     type: boolean
+//   - name: body
+//     in: body
+//     description: Recipes to upsert by id
+//     required: true
+//     schema: {type: array, items: {$ref: '#/definitions/Recipe'}}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Counts of inserted, updated and skipped recipes
+//	'400':
+//	    description: The body is not a JSON array
+//	'403':
+//	    description: Not an admin
+func (handler *RecipesHandler) ImportRecipesHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		respondError(c, http.StatusBadRequest, "bad_request", "Expected a JSON array of recipes")
+		return
+	}
+
+	dryRun, org := c.Query("dryRun") == "true", orgOf(c)
+	result := ImportResult{DryRun: dryRun, Errors: make([]BulkResult, 0)}
+	batch := make([]models.Recipe, 0, maxBulkSize)
+	indexes := make([]int, 0, maxBulkSize)
+	// checksums of the recipes a dry run has already matched, as the import
+	// would have left them, for ids sent more than once
+	previewed := make(map[primitive.ObjectID][sha256.Size]byte)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var err error
+		if dryRun {
+			err = handler.previewImportBatch(c.Request.Context(), org, batch, previewed, &result)
+		} else {
+			err = handler.writeImportBatch(c.Request.Context(), org, batch, indexes, &result)
+		}
+		batch, indexes = batch[:0], indexes[:0]
+		return err
+	}
+
+	user, _ := CurrentUser(c)
+	username := user.Username
+	for i := 0; decoder.More(); i++ {
+		var recipe models.Recipe
+		if err := decoder.Decode(&recipe); err != nil {
+			respondError(c, http.StatusBadRequest, "bad_request", fmt.Sprintf("Recipe %d: %s", i, err))
+			return
+		}
+		if recipe.ID.IsZero() {
+			result.Skipped++
+			continue
+		}
+		if err := binding.Validator.ValidateStruct(&recipe); err != nil {
+			fieldErrors, _ := toFieldErrors(err)
+			result.Errors = append(result.Errors, BulkResult{Index: i, Status: "invalid", ID: recipe.ID.Hex(), Errors: fieldErrors})
+			result.Failed++
+			continue
+		}
+		if recipe.Owner == "" {
+			recipe.Owner = username
+		}
+		recipe.OrgID = org
+		if recipe.PublishedAt.IsZero() {
+			recipe.PublishedAt = time.Now()
+		}
+		if recipe.CreatedAt.IsZero() {
+			recipe.CreatedAt = recipe.PublishedAt
+		}
+		if recipe.UpdatedAt.IsZero() {
+			recipe.UpdatedAt = recipe.CreatedAt
+		}
+		if recipe.Version == nil {
+			version := initialVersion
+			recipe.Version = &version
+		}
+		recipe.Score, recipe.Match = nil, nil
+
+		batch = append(batch, recipe)
+		indexes = append(indexes, i)
+		if len(batch) == maxBulkSize {
+			if err := flush(); err != nil {
+				respondDBError(c, err)
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// writeImportBatch upserts a batch of the import by id within org. indexes
+// are the positions of the recipes in the import, to report the failed ones.
+// A recipe of another organization with the same id is never matched, the
+// upsert then fails on the duplicate id.
+func (handler *RecipesHandler) writeImportBatch(ctx context.Context, org string, batch []models.Recipe, indexes []int, result *ImportResult) error {
+	writes := make([]mongo.WriteModel, len(batch))
+	ids := make([]string, len(batch))
+	for i, recipe := range batch {
+		writes[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": recipe.ID, "orgId": org}).
+			SetReplacement(recipe).
+			SetUpsert(true)
+		ids[i] = recipe.ID.Hex()
+	}
+	written, err := handler.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, writeErr := range bulkErr.WriteErrors {
+			result.Errors = append(result.Errors, BulkResult{Index: indexes[writeErr.Index], Status: "failed"})
+		}
+		result.Failed += int64(len(bulkErr.WriteErrors))
+	} else if err != nil {
+		return err
+	}
+	if written != nil {
+		result.Inserted += written.UpsertedCount
+		result.Updated += written.ModifiedCount
+		result.Skipped += written.MatchedCount - written.ModifiedCount
+	}
+	handler.invalidateCache(ids...)
+	return nil
+}
+
+// previewImportBatch counts what writeImportBatch would do with a batch
+// without writing it. Like MongoDB, it only counts a replacement as an update
+// when the document changes. Failures of the writes themselves can't be
+// foreseen.
+func (handler *RecipesHandler) previewImportBatch(ctx context.Context, org string, batch []models.Recipe, previewed map[primitive.ObjectID][sha256.Size]byte, result *ImportResult) error {
+	ids := make([]primitive.ObjectID, len(batch))
+	for i, recipe := range batch {
+		ids[i] = recipe.ID
+	}
+	cur, err := handler.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "orgId": org})
+	if err != nil {
+		return err
+	}
+	var stored []bson.Raw
+	if err := cur.All(ctx, &stored); err != nil {
+		return err
+	}
+	for _, doc := range stored {
+		if id, ok := doc.Lookup("_id").ObjectIDOK(); ok {
+			if _, seen := previewed[id]; !seen {
+				previewed[id] = sha256.Sum256(doc)
+			}
+		}
+	}
+
+	for _, recipe := range batch {
+		replacement, err := bson.Marshal(recipe)
+		if err != nil {
+			return err
+		}
+		current, found := previewed[recipe.ID]
+		switch {
+		case !found:
+			result.Inserted++
+		case current == sha256.Sum256(replacement):
+			result.Skipped++
+		default:
+			result.Updated++
+		}
+		previewed[recipe.ID] = sha256.Sum256(replacement)
+	}
+	return nil
+}
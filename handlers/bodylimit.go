@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxJSONDepth is how deeply objects and arrays may nest in a body, far more
+// than any recipe needs
+const maxJSONDepth = 32
+
+// BodyLimit answers 413 to requests whose body exceeds maxSize bytes and 400
+// to bodies nesting deeper than maxJSONDepth, before any handler parses them.
+// The body is read up front so both hold whatever the Content-Length says.
+// Routes in exempt, given as registered such as /recipes/:id/image, read their
+// body themselves with their own limit.
+func BodyLimit(maxSize int64, exempt ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody || skip[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > maxSize {
+			respondBodyTooLarge(c, maxSize)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSize))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondBodyTooLarge(c, maxSize)
+				return
+			}
+			respondError(c, http.StatusBadRequest, "bad_request", "Failed to read the request body")
+			return
+		}
+		if jsonDepth(body) > maxJSONDepth {
+			respondError(c, http.StatusBadRequest, "bad_request", fmt.Sprintf("JSON must not nest more than %d levels deep", maxJSONDepth))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+func respondBodyTooLarge(c *gin.Context, maxSize int64) {
+	respondError(c, http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("Request body must not exceed %d bytes", maxSize))
+}
+
+// jsonDepth returns how deeply the objects and arrays of data nest, ignoring
+// the brackets inside strings. It doesn't validate the JSON, the handlers do.
+func jsonDepth(data []byte) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, b := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch b {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case b == '"':
+			inString = true
+		case b == '{' || b == '[':
+			depth++
+			if depth > deepest {
+				deepest = depth
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return deepest
+}
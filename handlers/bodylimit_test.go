@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimit(t *testing.T) {
+	router := gin.New()
+	router.Use(BodyLimit(128, "/upload"))
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusOK, "application/json", body)
+	}
+	router.POST("/recipes", echo)
+	router.POST("/upload", echo)
+
+	small := `{"name": "Soup"}`
+	large := `{"name": "` + strings.Repeat("a", 128) + `"}`
+	deep := strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1)
+	tests := []struct {
+		name          string
+		path          string
+		body          string
+		unknownLength bool
+		status        int
+		code          string
+	}{
+		{"within the limit", "/recipes", small, false, http.StatusOK, ""},
+		{"oversized", "/recipes", large, false, http.StatusRequestEntityTooLarge, "payload_too_large"},
+		{"oversized without Content-Length", "/recipes", large, true, http.StatusRequestEntityTooLarge, "payload_too_large"},
+		{"nested too deep", "/recipes", deep, false, http.StatusBadRequest, "bad_request"},
+		{"exempt route", "/upload", large, false, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewBufferString(tt.body))
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.code == "" {
+				if w.Body.String() != tt.body {
+					t.Errorf("handler read %q, want %q", w.Body, tt.body)
+				}
+			} else if !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("body %s, want the %s envelope", w.Body, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// breakerRetryAfter is how long clients are asked to wait while the circuit
+// is open, about the interval at which the driver checks on the servers.
+const breakerRetryAfter = 10 * time.Second
+
+const degradedKey = "databaseDegraded"
+
+// Breaker is a circuit breaker in front of MongoDB. It opens when the driver
+// is left without a server to write to, which it learns from its heartbeats
+// and from failed operations, and closes once a heartbeat reaches a primary
+// again. While it is open, requests fail fast with a 503 instead of each one
+// waiting out the server selection timeout.
+type Breaker struct {
+	mu   sync.Mutex
+	seen bool
+	open bool
+}
+
+func NewBreaker() *Breaker {
+	return &Breaker{}
+}
+
+// ServerMonitor feeds the breaker with the topology changes seen by the
+// driver, it must be set on the client options.
+func (breaker *Breaker) ServerMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		TopologyDescriptionChanged: func(e *event.TopologyDescriptionChangedEvent) {
+			breaker.update(e.NewDescription.HasWritableServer())
+		},
+	}
+}
+
+// update only trips the breaker once a primary has been seen, so the servers
+// of a topology that is still being discovered don't open it.
+func (breaker *Breaker) update(writable bool) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if writable {
+		breaker.seen = true
+	}
+	if !breaker.seen || breaker.open == !writable {
+		return
+	}
+	breaker.open = !writable
+	if breaker.open {
+		slog.Warn("MongoDB is unreachable, serving degraded responses until it is back")
+		return
+	}
+	slog.Info("MongoDB is reachable again")
+}
+
+// Open reports whether MongoDB is currently unreachable.
+func (breaker *Breaker) Open() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.open
+}
+
+// Middleware answers 503 while the circuit is open, except on the degraded
+// routes, matched by FullPath, which are still let through to be served from
+// the cache alone. Their handlers check databaseUnavailable before falling
+// back to MongoDB.
+func (breaker *Breaker) Middleware(degraded ...string) gin.HandlerFunc {
+	served := make(map[string]bool, len(degraded))
+	for _, path := range degraded {
+		served[path] = true
+	}
+	return func(c *gin.Context) {
+		if !breaker.Open() {
+			c.Next()
+			return
+		}
+		c.Set(degradedKey, true)
+		if !served[c.FullPath()] {
+			databaseUnavailable(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// databaseUnavailable answers 503 and returns true when MongoDB can't be
+// reached, for handlers that can serve part of their requests without it.
+func databaseUnavailable(c *gin.Context) bool {
+	if !c.GetBool(degradedKey) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(breakerRetryAfter.Seconds())))
+	respondError(c, http.StatusServiceUnavailable, "database_unavailable", "Database is unreachable, try again later")
+	return true
+}
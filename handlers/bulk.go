@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+const maxBulkSize = 500
+
+// BulkResult reports what happened to one item of a bulk request, identified
+// by its position in the submitted array.
+type BulkResult struct {
+	Index  int          `json:"index"`
+	Status string       `json:"status"`
+	ID     string       `json:"id,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// swagger:operation POST /recipes/bulk recipes bulkCreateRecipes
+// Create up to 500 recipes at once
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: Recipes to create
+//     required: true
+//     schema: {type: array, maxItems: 500, items: {$ref: '#/definitions/Recipe'}}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'201':
+//	    description: All recipes were created
+//	'207':
+//	    description: Some recipes were rejected, see the per-item results
+//	'400':
+//	    description: Invalid input or too many recipes
+func (handler *RecipesHandler) BulkCreateHandler(c *gin.Context) {
+	var recipes []models.Recipe
+	if err := json.NewDecoder(c.Request.Body).Decode(&recipes); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if len(recipes) == 0 {
+		respondError(c, http.StatusBadRequest, "bad_request", "No recipes to create")
+		return
+	}
+	if len(recipes) > maxBulkSize {
+		respondError(c, http.StatusBadRequest, "bad_request", fmt.Sprintf("At most %d recipes can be created at once", maxBulkSize))
+		return
+	}
+
+	results := make([]BulkResult, len(recipes))
+	documents := make([]interface{}, 0, len(recipes))
+	indexes := make([]int, 0, len(recipes))
+	user, _ := CurrentUser(c)
+	username, org := user.Username, orgOf(c)
+	for i := range recipes {
+		results[i].Index = i
+		if err := binding.Validator.ValidateStruct(&recipes[i]); err != nil {
+			results[i].Status = "invalid"
+			results[i].Errors, _ = toFieldErrors(err)
+			continue
+		}
+
+		recipes[i].ID = primitive.NewObjectID()
+		recipes[i].PublishedAt = time.Now()
+		recipes[i].CreatedAt = recipes[i].PublishedAt
+		recipes[i].UpdatedAt = recipes[i].PublishedAt
+		recipes[i].Owner = username
+		recipes[i].OrgID = org
+		version := initialVersion
+		recipes[i].Version = &version
+		recipes[i].AvgRating, recipes[i].RatingCount, recipes[i].Views = 0, 0, 0
+		recipes[i].Images = nil
+		documents = append(documents, recipes[i])
+		indexes = append(indexes, i)
+	}
+
+	failed := make(map[int]bool)
+	if len(documents) > 0 {
+		_, err := handler.collection.InsertMany(c.Request.Context(), documents, options.InsertMany().SetOrdered(false))
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, writeErr := range bulkErr.WriteErrors {
+				failed[indexes[writeErr.Index]] = true
+			}
+		} else if err != nil {
+			respondError(c, http.StatusInternalServerError, "internal_error", "Error while inserting recipes")
+			return
+		}
+		handler.invalidateCache()
+	}
+
+	created := 0
+	for _, i := range indexes {
+		if failed[i] {
+			results[i].Status = "failed"
+			continue
+		}
+		results[i].Status = "created"
+		results[i].ID = recipes[i].ID.Hex()
+		handler.audit(c, "create", recipes[i].ID, recipeSnapshot(recipes[i]))
+		created++
+	}
+
+	status := http.StatusCreated
+	if created < len(recipes) {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"created": created,
+		"failed":  len(recipes) - created,
+		"results": results,
+	})
+}
+
+// swagger:operation DELETE /recipes recipes bulkDeleteRecipes
+// Delete up to 500 recipes at once
+//
+// The body is a JSON array of recipe IDs. Every recipe the user owns, or any
+// recipe of the organization for admins, is soft deleted; the others are reported as invalid,
+// not_found or forbidden in the per-item results.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: IDs of the recipes to delete
+//     required: true
+//     schema: {type: array, minItems: 1, maxItems: 500, items: {type: string}}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: All recipes were deleted
+//	'207':
+//	    description: Some recipes were not deleted, see the per-item results
+//	'400':
+//	    description: Invalid input or too many IDs
+func (handler *RecipesHandler) BulkDeleteHandler(c *gin.Context) {
+	var ids []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&ids); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if len(ids) == 0 {
+		respondError(c, http.StatusBadRequest, "bad_request", "No recipes to delete")
+		return
+	}
+	if len(ids) > maxBulkSize {
+		respondError(c, http.StatusBadRequest, "bad_request", fmt.Sprintf("At most %d recipes can be deleted at once", maxBulkSize))
+		return
+	}
+
+	results := make([]BulkResult, len(ids))
+	objectIds := make([]primitive.ObjectID, 0, len(ids))
+	for i, id := range ids {
+		results[i] = BulkResult{Index: i, ID: id}
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			results[i].Status = "invalid"
+			continue
+		}
+		objectIds = append(objectIds, objectId)
+	}
+
+	cur, err := handler.collection.Find(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       bson.M{"$in": objectIds},
+		"deletedAt": notDeleted,
+	}), options.Find().SetProjection(bson.M{"owner": 1}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	var recipes []models.Recipe
+	if err := cur.All(c.Request.Context(), &recipes); err != nil {
+		respondDBError(c, err)
+		return
+	}
+	owners := make(map[primitive.ObjectID]string, len(recipes))
+	for _, recipe := range recipes {
+		owners[recipe.ID] = recipe.Owner
+	}
+
+	user, _ := CurrentUser(c)
+	username := user.Username
+	admin := hasRole(c, "admin")
+	authorized := make(map[primitive.ObjectID]bool)
+	for i := range results {
+		if results[i].Status != "" {
+			continue
+		}
+		objectId, _ := primitive.ObjectIDFromHex(results[i].ID)
+		owner, found := owners[objectId]
+		switch {
+		case !found:
+			results[i].Status = "not_found"
+		case owner != username && !admin:
+			results[i].Status = "forbidden"
+		default:
+			results[i].Status = "deleted"
+			authorized[objectId] = true
+		}
+	}
+
+	deleted := make([]primitive.ObjectID, 0, len(authorized))
+	for objectId := range authorized {
+		deleted = append(deleted, objectId)
+	}
+	if len(deleted) > 0 {
+		// a recipe deleted by someone else in the meantime is just as deleted,
+		// so it is still reported as such
+		_, err := handler.collection.UpdateMany(c.Request.Context(), inOrg(c, bson.M{
+			"_id":       bson.M{"$in": deleted},
+			"deletedAt": notDeleted,
+		}), bson.M{"$set": bson.M{"deletedAt": time.Now()}})
+		if err != nil {
+			respondDBError(c, err)
+			return
+		}
+
+		keys := make([]string, len(deleted))
+		for i, objectId := range deleted {
+			keys[i] = objectId.Hex()
+			handler.audit(c, "delete", objectId, nil)
+		}
+		handler.invalidateCache(keys...)
+	}
+
+	count := 0
+	for _, result := range results {
+		if result.Status == "deleted" {
+			count++
+		}
+	}
+	status := http.StatusOK
+	if count < len(ids) {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"deleted": count,
+		"failed":  len(ids) - count,
+		"results": results,
+	})
+}
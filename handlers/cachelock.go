@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"time"
+
+	redis "github.com/go-redis/redis"
+	"github.com/rs/xid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+const (
+	// rebuildLockTTL frees the lock of a request that died while rebuilding
+	rebuildLockTTL = 5 * time.Second
+	// rebuildWait is how long requests wait for another one to rebuild the
+	// cache before they query MongoDB themselves
+	rebuildWait  = 2 * time.Second
+	rebuildPoll  = 50 * time.Millisecond
+	rebuildLocks = "lock:rebuild:"
+)
+
+// unlockScript deletes a lock only while it still holds the caller's token,
+// so a request whose lock expired can't free the lock of the next one.
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// cacheLookup reads the cache entry key through lookup. On a miss, only one
+// request at a time gets to rebuild the entry, so a popular entry expiring
+// doesn't send every request to MongoDB: the one that gets the lock must call
+// unlock once it cached the entry. The others poll lookup until the entry
+// shows up, and rebuild it themselves when the lock is released without it,
+// e.g. for a missing recipe, or after rebuildWait. err is only set when
+// the first lookup fails for another reason than a miss.
+func (handler *RecipesHandler) cacheLookup(ctx context.Context, key string, lookup func() (string, error)) (val string, found bool, unlock func(), err error) {
+	// go-redis v6 commands don't take a context, so the cache read gets a
+	// span of its own here instead
+	ctx, span := tracer.Start(ctx, "cache.lookup", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("cache.hit", found))
+		span.End()
+	}()
+
+	unlock = func() {}
+	val, err = lookup()
+	if err == nil {
+		return val, true, unlock, nil
+	}
+	if err != redis.Nil {
+		return "", false, unlock, err
+	}
+
+	lockKey := rebuildLocks + key
+	token := xid.New().String()
+	acquired, err := handler.redisClient.SetNX(lockKey, token, rebuildLockTTL).Result()
+	if err != nil {
+		loggerFrom(ctx).Warn("Failed to lock cache rebuild", "error", err)
+		return "", false, unlock, nil
+	}
+	if acquired {
+		return "", false, func() {
+			if err := unlockScript.Run(handler.redisClient, []string{lockKey}, token).Err(); err != nil {
+				loggerFrom(ctx).Warn("Failed to unlock cache rebuild", "error", err)
+			}
+		}, nil
+	}
+
+	deadline := time.NewTimer(rebuildWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(rebuildPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", false, unlock, nil
+		case <-deadline.C:
+			return "", false, unlock, nil
+		case <-ticker.C:
+		}
+		val, err := lookup()
+		if err == nil {
+			return val, true, unlock, nil
+		}
+		if err != redis.Nil {
+			loggerFrom(ctx).Warn("Failed to read cache", "error", err)
+			return "", false, unlock, nil
+		}
+		if handler.redisClient.Exists(lockKey).Val() == 0 {
+			return "", false, unlock, nil
+		}
+	}
+}
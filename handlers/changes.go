@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+)
+
+// changeStreamsUnsupported is the error code of a change stream opened on a
+// standalone server
+const changeStreamsUnsupported = 40573
+
+const watchRetry = 5 * time.Second
+
+// WatchChanges invalidates the cached recipes changed in MongoDB, by the API
+// or anyone else, until ctx is cancelled. The write handlers still invalidate
+// the cache themselves, so a client reads its own writes without waiting on
+// the change stream, and they are all there is on a standalone server, where
+// WatchChanges returns right away.
+func (handler *RecipesHandler) WatchChanges(ctx context.Context) {
+	var resumeToken bson.Raw
+	for {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+		stream, err := handler.collection.Watch(ctx, mongo.Pipeline{}, opts)
+		if err != nil {
+			var serverErr mongo.ServerError
+			if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamsUnsupported) {
+				slog.Info("Change streams are not available, the cache only follows the writes made through the API")
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Failed to watch recipes, retrying", "error", err, "in", watchRetry)
+			if !sleep(ctx, watchRetry) {
+				return
+			}
+			continue
+		}
+
+		for stream.Next(ctx) {
+			var change struct {
+				OperationType string `bson:"operationType"`
+				DocumentKey   struct {
+					ID primitive.ObjectID `bson:"_id"`
+				} `bson:"documentKey"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				slog.Warn("Failed to decode recipe change", "error", err)
+				continue
+			}
+			switch change.OperationType {
+			case "insert", "update", "replace", "delete":
+				handler.invalidateCache(change.DocumentKey.ID.Hex())
+			default:
+				// the collection was dropped or renamed: the stream can't be
+				// resumed and the cached recipes expire on their own
+				handler.invalidateCache()
+				resumeToken = nil
+				continue
+			}
+			resumeToken = stream.ResumeToken()
+		}
+		err = stream.Err()
+		stream.Close(context.Background())
+		if ctx.Err() != nil {
+			return
+		}
+		// an idle stream times out with the operation timeout, it is simply
+		// resumed
+		if err != nil && !mongo.IsTimeout(err) {
+			slog.Warn("Recipe change stream failed, resuming", "error", err, "in", watchRetry)
+			if !sleep(ctx, watchRetry) {
+				return
+			}
+		}
+	}
+}
+
+// sleep waits for d, returning false when ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
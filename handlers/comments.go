@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// comments is the collection holding the comments of every recipe.
+func (handler *RecipesHandler) comments() *mongo.Collection {
+	return handler.collection.Database().Collection("comments")
+}
+
+// recipeExists answers 404 and returns false when the recipe is missing,
+// deleted or in another organization.
+func (handler *RecipesHandler) recipeExists(c *gin.Context, objectId primitive.ObjectID) bool {
+	count, err := handler.collection.CountDocuments(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	}))
+	if err != nil {
+		respondDBError(c, err)
+		return false
+	}
+	if count == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return false
+	}
+	return true
+}
+
+// swagger:operation POST /recipes/{id}/comments comments newComment
+// Comment on a recipe
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     description: The comment
+//     required: true
+//     schema: {$ref: '#/definitions/NewComment'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'201':
+//	    description: The created comment
+//	'400':
+//	    description: Invalid recipe ID, or empty or too long body
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) NewCommentHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	var comment models.Comment
+	if !bindJSON(c, &comment) {
+		return
+	}
+	comment.Body = strings.TrimSpace(comment.Body)
+	if comment.Body == "" {
+		respondValidationError(c, []FieldError{{Field: "body", Message: "is required"}})
+		return
+	}
+	if !handler.recipeExists(c, objectId) {
+		return
+	}
+
+	comment.ID = primitive.NewObjectID()
+	comment.RecipeID = objectId
+	user, _ := CurrentUser(c)
+	comment.Author = user.Username
+	comment.CreatedAt = time.Now()
+	if _, err := handler.comments().InsertOne(c.Request.Context(), comment); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, comment)
+}
+
+// swagger:operation GET /recipes/{id}/comments comments listComments
+// Returns a page of the comments of a recipe, oldest first
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: limit
+//     in: query
+//     description: number of comments per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid recipe ID or query parameters
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) ListCommentsHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if !handler.recipeExists(c, objectId) {
+		return
+	}
+
+	filter := bson.M{"recipeId": objectId}
+	total, err := handler.comments().CountDocuments(c.Request.Context(), filter)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cur, err := handler.comments().Find(c.Request.Context(), filter, opts)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(c.Request.Context())
+
+	comments := make([]models.Comment, 0)
+	if err := cur.All(c.Request.Context(), &comments); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	list := models.CommentList{
+		Data:       comments,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: (total + limit - 1) / limit,
+	}
+	setPageLinks(c, list.Page, list.TotalPages)
+	c.JSON(http.StatusOK, list)
+}
+
+// swagger:operation DELETE /recipes/{id}/comments/{commentId} comments deleteComment
+// Delete a comment, only its author or an admin can
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: commentId
+//     in: path
+//     description: ID of the comment
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Comment deleted
+//	'400':
+//	    description: Invalid ID
+//	'403':
+//	    description: Not the author of the comment
+//	'404':
+//	    description: Recipe or comment not found
+func (handler *RecipesHandler) DeleteCommentHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	commentId, ok := parseObjectID(c, c.Param("commentId"))
+	if !ok {
+		return
+	}
+	if !handler.recipeExists(c, objectId) {
+		return
+	}
+
+	filter := bson.M{"_id": commentId, "recipeId": objectId}
+	var comment models.Comment
+	err := handler.comments().FindOne(c.Request.Context(), filter).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Comment not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if user, _ := CurrentUser(c); comment.Author != user.Username && !hasRole(c, "admin") {
+		respondError(c, http.StatusForbidden, "forbidden", "You are not the author of this comment")
+		return
+	}
+
+	if _, err := handler.comments().DeleteOne(c.Request.Context(), filter); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comment has been deleted"})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+func TestCompress(t *testing.T) {
+	handler := &RecipesHandler{xmlOutput: true}
+	recipe := func(c *gin.Context) models.Recipe {
+		steps := 1
+		if c.Query("size") == "large" {
+			steps = 200
+		}
+		return models.Recipe{Name: "Bread", Instructions: strings.Split(strings.Repeat("Knead the dough,", steps), ",")}
+	}
+	router := gin.New()
+	router.Use(Compress(1024))
+	router.GET("/recipe", func(c *gin.Context) {
+		if _, text := handler.wantsText(c, "id"); text {
+			respondRecipeText(c, recipe(c))
+			return
+		}
+		handler.respond(c, http.StatusOK, recipe(c))
+	})
+
+	for _, accept := range []string{"application/json", "application/xml", "text/plain"} {
+		for _, size := range []string{"small", "large"} {
+			w := serve(router, http.MethodGet, "/recipe?size="+size, "", "Accept", accept, "Accept-Encoding", "gzip")
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), accept) {
+				t.Errorf("%s %s: Content-Type %q", accept, size, w.Header().Get("Content-Type"))
+			}
+			checkVary(t, w.Header(), "Accept-Encoding", "Accept")
+
+			body := w.Body.String()
+			if size == "large" {
+				if w.Header().Get("Content-Encoding") != "gzip" {
+					t.Errorf("%s large: Content-Encoding %q, want gzip", accept, w.Header().Get("Content-Encoding"))
+					continue
+				}
+				reader, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("%s large: %v", accept, err)
+				}
+				data, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("%s large: %v", accept, err)
+				}
+				body = string(data)
+			} else if w.Header().Get("Content-Encoding") != "" {
+				t.Errorf("%s small: Content-Encoding %q, want none", accept, w.Header().Get("Content-Encoding"))
+			}
+			if !strings.Contains(body, "Knead the dough") {
+				t.Errorf("%s %s: body %q", accept, size, body)
+			}
+		}
+	}
+}
+
+func TestCompressSkipsClientsWithoutGzip(t *testing.T) {
+	router := gin.New()
+	router.Use(Compress(16))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat("a", 4096))
+	})
+
+	w := serve(router, http.MethodGet, "/", "", "Accept-Encoding", "gzip;q=0")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 4096 {
+		t.Errorf("got Content-Encoding %q and %d bytes, want the body as is", w.Header().Get("Content-Encoding"), w.Body.Len())
+	}
+	checkVary(t, w.Header(), "Accept-Encoding")
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// CORS answers preflight requests and adds the CORS headers for the allowed
+// origins. "*" allows any origin but, as browsers require, without
+// credentials; explicitly listed origins are echoed back with credentials
+// allowed so the session cookie is sent.
+//
+// allowedHeaders are the request headers scripts may send and exposedHeaders
+// the response headers they may read besides the few browsers always expose.
+// Browsers cache a preflight for maxAge, zero leaves it to their default.
+func CORS(origins, allowedHeaders, exposedHeaders []string, maxAge time.Duration) gin.HandlerFunc {
+	allowHeaders := strings.Join(allowedHeaders, ", ")
+	exposeHeaders := strings.Join(exposedHeaders, ", ")
+	maxAgeSeconds := strconv.Itoa(int(maxAge.Seconds()))
+	allowAny := false
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			allowAny = true
+		} else if origin != "" {
+			allowed[origin] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+		if allowed[origin] {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		} else if allowAny {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Next()
+			return
+		}
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", corsAllowedMethods)
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+			if maxAge > 0 {
+				c.Header("Access-Control-Max-Age", maxAgeSeconds)
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if exposeHeaders != "" {
+			c.Header("Access-Control-Expose-Headers", exposeHeaders)
+		}
+		c.Next()
+	}
+}
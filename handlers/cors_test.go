@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	called := false
+	router := gin.New()
+	router.Use(CORS([]string{"https://app.example.com"}, []string{"Authorization", "Content-Type"}, []string{"ETag"}, time.Hour))
+	router.Any("/recipes", func(c *gin.Context) {
+		called = true
+		c.Status(http.StatusOK)
+	})
+
+	w := serve(router, http.MethodOptions, "/recipes", "",
+		"Origin", "https://app.example.com",
+		"Access-Control-Request-Method", "POST",
+		"Access-Control-Request-Headers", "Authorization")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: got %d, want 204", w.Code)
+	}
+	if called {
+		t.Error("preflight reached the handler")
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+		"Access-Control-Max-Age":           "3600",
+	}
+	for name, value := range want {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("preflight %s: %q, want %q", name, got, value)
+		}
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Error("preflight: no Access-Control-Allow-Methods")
+	}
+	checkVary(t, w.Header(), "Origin")
+
+	// the actual request gets the exposed headers instead
+	w = serve(router, http.MethodGet, "/recipes", "", "Origin", "https://app.example.com")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Expose-Headers") != "ETag" || w.Header().Get("Access-Control-Max-Age") != "" {
+		t.Errorf("request: got %d with %v", w.Code, w.Header())
+	}
+
+	w = serve(router, http.MethodOptions, "/recipes", "",
+		"Origin", "https://evil.example.com",
+		"Access-Control-Request-Method", "POST")
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("other origin: allowed with %v", w.Header())
+	}
+}
+
+// checkVary fails the test unless every one of names is listed exactly
+// once in the Vary header.
+func checkVary(t *testing.T, header http.Header, names ...string) {
+	t.Helper()
+	listed := map[string]int{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			listed[http.CanonicalHeaderKey(strings.TrimSpace(name))]++
+		}
+	}
+	for _, name := range names {
+		if listed[name] != 1 {
+			t.Errorf("Vary %q: want %s once", header.Values("Vary"), name)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// swagger:operation GET /recipes/count recipes countRecipes
+// Returns the number of recipes
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: tag
+//     in: query
+//     description: tag to filter by, can be repeated
+//     required: false
+//     type: array
+//     items: {type: string}
+//     collectionFormat: multi
+//   - name: match
+//     in: query
+//     description: whether recipes must have all or any of the tags (default any)
+//     required: false
+//     type: string
+//     enum: [all, any]
+//   - name: mine
+//     in: query
+//     description: only count recipes created by the current user
+//     required: false
+//     type: boolean
+//   - name: cuisine
+//     in: query
+//     description: only count recipes of this cuisine
+//     required: false
+//     type: string
+//   - name: difficulty
+//     in: query
+//     description: only count recipes of this difficulty
+//     required: false
+//     type: string
+//     enum: [easy, medium, hard]
+//   - name: maxPrep
+//     in: query
+//     description: only count recipes taking at most this many minutes to prepare
+//     required: false
+//     type: integer
+//   - name: maxCook
+//     in: query
+//     description: only count recipes taking at most this many minutes to cook
+//     required: false
+//     type: integer
+//   - name: maxTotal
+//     in: query
+//     description: only count recipes whose preparation and cooking take at most this many minutes together
+//     required: false
+//     type: integer
+//   - name: q
+//     in: query
+//     description: only count recipes whose name or ingredients match these search terms
+//     required: false
+//     type: string
+//   - name: includeDeleted
+//     in: query
+//     description: also count deleted recipes, admins only
+//     required: false
+//     type: boolean
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid query parameters
+//	'403':
+//	    description: includeDeleted used by a non-admin
+func (handler *RecipesHandler) CountRecipesHandler(c *gin.Context) {
+	if c.Query("includeDeleted") == "true" && !hasRole(c, "admin") {
+		respondError(c, http.StatusForbidden, "forbidden", "Only admins can count deleted recipes")
+		return
+	}
+	filter, filterKey, err := parseRecipeFilter(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	q := strings.TrimSpace(c.Query("q"))
+
+	// counts share the "recipes" hash with the list pages so writes
+	// invalidate them together; without Redis they are counted in MongoDB
+	cacheField := "count:" + filterKey + ":q=" + q
+	if handler.cacheTTL > 0 {
+		val, err := handler.redisClient.HGet("recipes", cacheField).Int64()
+		if err != nil && err != redis.Nil {
+			loggerFrom(c.Request.Context()).Warn("Failed to read recipe count from cache", "error", err)
+		}
+		if err == nil {
+			c.Header("X-Cache", "HIT")
+			c.JSON(http.StatusOK, gin.H{"count": val})
+			return
+		}
+	}
+
+	if q != "" {
+		search, _, err := handler.searchFilter(c.Request.Context(), q)
+		if err != nil {
+			respondDBError(c, err)
+			return
+		}
+		for key, value := range search {
+			filter[key] = value
+		}
+	}
+
+	loggerFrom(c.Request.Context()).Debug("Request to MongoDB")
+	count, err := handler.collection.CountDocuments(c.Request.Context(), filter)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	if handler.cacheTTL > 0 {
+		handler.cacheListVariant(cacheField, strconv.FormatInt(count, 10))
+	}
+	c.Header("X-Cache", "MISS")
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
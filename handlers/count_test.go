@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCountRecipesWithRedisDown(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, redisServer := testRedis(t)
+	handler := testRecipesHandler(t, db, redisClient)
+	router := recipesRouter(handler, AuthUser{Username: "cook"})
+	router.GET("/count", handler.CountRecipesHandler)
+	createRecipe(t, router, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`)
+
+	redisServer.Close()
+	w := serve(router, http.MethodGet, "/count", "")
+	var body struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK || body.Count != 1 {
+		t.Fatalf("got %d %s, want 200 with a count of 1", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// swagger:operation GET /recipes/export.csv recipes exportRecipesCSV
+// Export recipes as CSV
+//
+// Takes the same filters as the list, plus the search terms of the search
+// endpoint. Rows are streamed from a cursor.
+// ---
+// produces:
+// - text/csv
+// parameters:
+//   - name: tag
+//     in: query
+//     description: tag to filter by, can be repeated
+//     required: false
+//     type: array
+//     items: {type: string}
+//     collectionFormat: multi
+//   - name: match
+//     in: query
+//     description: whether recipes must have all or any of the tags (default any)
+//     required: false
+//     type: string
+//     enum: [all, any]
+//   - name: mine
+//     in: query
+//     description: only export recipes created by the current user
+//     required: false
+//     type: boolean
+//   - name: cuisine
+//     in: query
+//     description: only export recipes of this cuisine
+//     required: false
+//     type: string
+//   - name: difficulty
+//     in: query
+//     description: only export recipes of this difficulty
+//     required: false
+//     type: string
+//     enum: [easy, medium, hard]
+//   - name: maxPrep
+//     in: query
+//     description: only export recipes taking at most this many minutes to prepare
+//     required: false
+//     type: integer
+//   - name: maxCook
+//     in: query
+//     description: only export recipes taking at most this many minutes to cook
+//     required: false
+//     type: integer
+//   - name: maxTotal
+//     in: query
+//     description: only export recipes whose preparation and cooking take at most this many minutes together
+//     required: false
+//     type: integer
+//   - name: q
+//     in: query
+//     description: only export recipes whose name or ingredients match these search terms
+//     required: false
+//     type: string
+//   - name: sort
+//     in: query
+//     description: sort order, prefix with - for descending
+//     required: false
+//     type: string
+//     enum: [name, -name, publishedAt, -publishedAt, avgRating, -avgRating]
+//   - name: includeDeleted
+//     in: query
+//     description: also export deleted recipes, admins only
+//     required: false
+//     type: boolean
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: One row per recipe with its name, tags and number of ingredients
+//	'400':
+//	    description: Invalid query parameters
+//	'403':
+//	    description: includeDeleted used by a non-admin
+func (handler *RecipesHandler) ExportCSVHandler(c *gin.Context) {
+	if c.Query("includeDeleted") == "true" && !hasRole(c, "admin") {
+		respondError(c, http.StatusForbidden, "forbidden", "Only admins can export deleted recipes")
+		return
+	}
+	// the export streams for as long as it takes, past REQUEST_TIMEOUT
+	ctx := context.WithoutCancel(c.Request.Context())
+	filter, _, err := parseRecipeFilter(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	sortDoc, err := parseSort(c.Query("sort"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		search, _, err := handler.searchFilter(ctx, q)
+		if err != nil {
+			respondDBError(c, err)
+			return
+		}
+		for key, value := range search {
+			filter[key] = value
+		}
+	}
+
+	cur, err := handler.collection.Find(ctx, filter, options.Find().SetSort(sortDoc))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(ctx)
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="recipes-%s.csv"`, time.Now().Format("20060102")))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"name", "tags", "ingredients"})
+	for count := 1; cur.Next(ctx); count++ {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			loggerFrom(c.Request.Context()).Error("Failed to decode recipe during CSV export", "error", err)
+			break
+		}
+		writer.Write([]string{
+			csvCell(recipe.Name),
+			csvCell(strings.Join(recipe.Tags, ";")),
+			strconv.Itoa(len(recipe.Ingredients)),
+		})
+		if count%maxBulkSize == 0 {
+			writer.Flush()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		loggerFrom(c.Request.Context()).Warn("CSV export interrupted", "error", err)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		loggerFrom(c.Request.Context()).Warn("CSV export interrupted", "error", err)
+	}
+}
+
+// csvCell keeps spreadsheets from evaluating user input as a formula by
+// prefixing cells that start like one with a quote. Commas, quotes and line
+// breaks are escaped by the csv writer.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// recipeCursor is the position of the last recipe of a page in the sort
+// order: the value of the sort field, nil when the recipe doesn't have it,
+// and the id breaking the ties. Sort is the order the cursor was read in, a
+// cursor can't be used to continue another one.
+type recipeCursor struct {
+	Sort  string             `bson:"s"`
+	Value interface{}        `bson:"v,omitempty"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// encodeCursor makes the cursor opaque to clients. BSON keeps the type of
+// the sort value, so dates compare as dates when the cursor comes back.
+func encodeCursor(cursor recipeCursor) string {
+	data, _ := bson.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value, sortValue string) (recipeCursor, error) {
+	var cursor recipeCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || bson.Unmarshal(data, &cursor) != nil {
+		return recipeCursor{}, errInvalidCursor
+	}
+	if cursor.Sort != sortValue {
+		return recipeCursor{}, fmt.Errorf("cursor was read with sort %q", cursor.Sort)
+	}
+	return cursor, nil
+}
+
+// afterCursor filters the recipes that come after cursor in the order of
+// sortDoc, as built by parseSort. MongoDB sorts a missing field before any
+// value, which the filter follows for recipes without one.
+func afterCursor(sortDoc bson.D, cursor recipeCursor) bson.M {
+	after := bson.M{"_id": bson.M{"$gt": cursor.ID}}
+	if len(sortDoc) == 1 {
+		return after
+	}
+	field, order := sortDoc[0].Key, sortDoc[0].Value.(int)
+	after[field] = cursor.Value
+
+	if cursor.Value == nil {
+		if order < 0 {
+			return after
+		}
+		return bson.M{"$or": bson.A{after, bson.M{field: bson.M{"$ne": nil}}}}
+	}
+	operator := "$gt"
+	if order < 0 {
+		operator = "$lt"
+	}
+	next := bson.A{bson.M{field: bson.M{operator: cursor.Value}}, after}
+	if order < 0 {
+		next = append(next, bson.M{field: nil})
+	}
+	return bson.M{"$or": next}
+}
+
+// findAfter reads the page of limit recipes following cursor, or the first
+// one when cursor is nil. One more recipe is read to tell whether the page is
+// the last.
+func (handler *RecipesHandler) findAfter(ctx context.Context, filter bson.M, sortValue string, sortDoc bson.D, fields fieldSelection, cursor *recipeCursor, limit int64) (models.RecipeCursorPage, error) {
+	if cursor != nil {
+		filter = bson.M{"$and": bson.A{filter, afterCursor(sortDoc, *cursor)}}
+	}
+	opts := options.Find().SetSort(sortDoc).SetLimit(limit + 1)
+	// the sort field is read for the next cursor even when it isn't selected
+	if projection := fields.projection(sortDoc[0].Key); projection != nil {
+		opts.SetProjection(projection)
+	}
+	cur, err := handler.listCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return models.RecipeCursorPage{}, err
+	}
+	var docs []bson.Raw
+	if err := cur.All(ctx, &docs); err != nil {
+		return models.RecipeCursorPage{}, err
+	}
+
+	page := models.RecipeCursorPage{Data: make([]models.Recipe, 0, len(docs)), Limit: limit}
+	if int64(len(docs)) > limit {
+		docs = docs[:limit]
+		// read the sort value from the document itself, the decoded recipe
+		// can't tell a missing rating from a zero one
+		last := docs[len(docs)-1]
+		next := recipeCursor{Sort: sortValue}
+		next.ID, _ = last.Lookup("_id").ObjectIDOK()
+		if len(sortDoc) > 1 {
+			if value, err := last.LookupErr(sortDoc[0].Key); err == nil && value.Type != bson.TypeNull {
+				value.Unmarshal(&next.Value)
+			}
+		}
+		page.NextCursor = encodeCursor(next)
+	}
+	for _, doc := range docs {
+		var recipe models.Recipe
+		bson.Unmarshal(doc, &recipe)
+		page.Data = append(page.Data, recipe)
+	}
+	return page, nil
+}
+
+// listAfterCursor serves GET /recipes in cursor mode. Unlike pages, cursors
+// don't skip or repeat recipes when others are added or removed while a
+// client walks through the list.
+func (handler *RecipesHandler) listAfterCursor(c *gin.Context, filter bson.M, filterKey, sortValue string, sortDoc bson.D, fields fieldSelection, limit int64) {
+	var cursor *recipeCursor
+	if value := c.Query("cursor"); value != "" {
+		decoded, err := decodeCursor(value, sortValue)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		cursor = &decoded
+	}
+
+	cacheField := fmt.Sprintf("cursor=%s:limit=%d:sort=%s:fields=%s:%s", c.Query("cursor"), limit, sortValue, fields.key(), filterKey)
+	if handler.cacheTTL > 0 {
+		val, found, unlock, err := handler.cacheLookup(c.Request.Context(), "recipes:"+cacheField, func() (string, error) {
+			return handler.redisClient.HGet("recipes", cacheField).Result()
+		})
+		if err != nil {
+			loggerFrom(c.Request.Context()).Warn("Failed to read recipes from cache", "error", err)
+		}
+		defer unlock()
+		if found {
+			var page models.RecipeCursorPage
+			json.Unmarshal([]byte(val), &page)
+			c.Header("X-Cache", "HIT")
+			setCursorLink(c, page.NextCursor)
+			handler.respondWithETag(c, fields.apply(page))
+			return
+		}
+	}
+
+	if databaseUnavailable(c) {
+		return
+	}
+	page, err := handler.findAfter(c.Request.Context(), filter, sortValue, sortDoc, fields, cursor, limit)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	if handler.cacheTTL > 0 {
+		data, _ := json.Marshal(fields.apply(page))
+		handler.cacheListVariant(cacheField, string(data))
+	}
+	c.Header("X-Cache", "MISS")
+	setCursorLink(c, page.NextCursor)
+	handler.respondWithETag(c, fields.apply(page))
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// swaggerUI loads Swagger UI from a CDN and points it at the spec served
+// next to it, so no UI assets need to be shipped with the binary.
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>Recipes API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		window.onload = () => {
+			window.ui = SwaggerUIBundle({
+				url: "{{basePath}}/swagger.json",
+				dom_id: "#swagger-ui",
+			});
+		};
+	</script>
+</body>
+</html>`
+
+type DocsHandler struct {
+	spec []byte
+	ui   []byte
+}
+
+// NewDocsHandler serves spec with its basePath set to the one the API is
+// mounted on, the root for an empty basePath.
+func NewDocsHandler(spec []byte, basePath string) *DocsHandler {
+	return &DocsHandler{
+		spec: withBasePath(spec, basePath),
+		ui:   []byte(strings.Replace(swaggerUI, "{{basePath}}", basePath, 1)),
+	}
+}
+
+func withBasePath(spec []byte, basePath string) []byte {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(spec, &document); err != nil {
+		slog.Warn("Failed to set the basePath of the API spec", "error", err)
+		return spec
+	}
+	if basePath == "" {
+		basePath = "/"
+	}
+	document["basePath"], _ = json.Marshal(basePath)
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		slog.Warn("Failed to set the basePath of the API spec", "error", err)
+		return spec
+	}
+	return data
+}
+
+// SpecHandler serves the OpenAPI document.
+func (handler *DocsHandler) SpecHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", handler.spec)
+}
+
+// UIHandler serves the Swagger UI page for any path under /swagger/.
+func (handler *DocsHandler) UIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", handler.ui)
+}
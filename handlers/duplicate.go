@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// swagger:operation POST /recipes/{id}/duplicate recipes duplicateRecipe
+// Copy a recipe into a new one owned by the current user
+//
+// The copy gets the name followed by "(copy)" and the same tags, cuisine,
+// servings, difficulty, times, ingredients and instructions. Its image, favorites, ratings and comments
+// start out empty.
+// ---
+// produces:
+// - application/json
+// - application/xml
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe to copy
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'201':
+//	    description: The new recipe
+//	'400':
+//	    description: Invalid recipe ID
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) DuplicateRecipeHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var original models.Recipe
+	err := handler.collection.FindOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	})).Decode(&original)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	now := time.Now()
+	version := initialVersion
+	user, _ := CurrentUser(c)
+	recipe := models.Recipe{
+		ID:           primitive.NewObjectID(),
+		Name:         original.Name + " (copy)",
+		Tags:         append([]string(nil), original.Tags...),
+		Cuisine:      original.Cuisine,
+		Servings:     original.Servings,
+		Difficulty:   original.Difficulty,
+		PrepTime:     original.PrepTime,
+		CookTime:     original.CookTime,
+		Ingredients:  append([]string(nil), original.Ingredients...),
+		Instructions: append([]string(nil), original.Instructions...),
+		PublishedAt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Owner:        user.Username,
+		OrgID:        original.OrgID,
+		Version:      &version,
+	}
+	if _, err := handler.collection.InsertOne(c.Request.Context(), recipe); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	handler.invalidateCache()
+	handler.audit(c, "create", recipe.ID, recipeSnapshot(recipe))
+
+	handler.respond(c, http.StatusCreated, recipe)
+}
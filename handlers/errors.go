@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIError is the body of every error response, wrapped in an "error" field.
+// Code is a stable machine-readable identifier, Message is meant for humans
+// and Fields lists the failed validations when there are any. RecipeID is
+// the existing recipe a duplicate_recipe conflict is about.
+type APIError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"requestId,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RecipeID  string       `json:"recipeId,omitempty"`
+}
+
+// errorCodes are the codes used for each status when a handler has nothing
+// more specific to say.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusLocked:                "locked",
+	http.StatusPreconditionRequired:  "precondition_required",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+}
+
+// errorCode returns the default code for status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return "error"
+}
+
+// respondError aborts the request with the error envelope. It works the same
+// from handlers and middlewares.
+func respondError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": APIError{
+		Code:      code,
+		Message:   message,
+		RequestID: GetRequestID(c),
+	}})
+}
+
+// respondValidationError aborts the request with a 400 listing the fields
+// that failed validation.
+func respondValidationError(c *gin.Context, fieldErrors []FieldError) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": APIError{
+		Code:      "validation_failed",
+		Message:   "The request body is invalid",
+		RequestID: GetRequestID(c),
+		Fields:    fieldErrors,
+	}})
+}
+
+// respondDBError answers a failed MongoDB or Redis call. Timeouts become a
+// 503 so clients know to retry, anything else is a 500.
+func respondDBError(c *gin.Context, err error) {
+	if mongo.IsTimeout(err) {
+		loggerFrom(c.Request.Context()).Error("Database operation timed out", "error", err)
+		respondError(c, http.StatusServiceUnavailable, "database_unavailable", "Database is not responding, try again later")
+		return
+	}
+	loggerFrom(c.Request.Context()).Error("Database operation failed", "error", err)
+	respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+}
+
+// Recovery answers panics with a 500 in the error envelope instead of an
+// empty response, after logging the panic and its stack trace.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+	})
+}
+
+// NotFoundHandler answers requests to unknown routes with the error envelope.
+func NotFoundHandler(c *gin.Context) {
+	respondError(c, http.StatusNotFound, "route_not_found", "No route for "+c.Request.Method+" "+c.Request.URL.Path)
+}
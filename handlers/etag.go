@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// respondWithETag writes body like respond, with a strong ETag derived from
+// its content, answering 304 Not Modified instead when the client already
+// holds the same representation.
+func (handler *RecipesHandler) respondWithETag(c *gin.Context, body interface{}) {
+	handler.respondTagged(c, "", body)
+}
+
+// respondRecipeWithETag is respondWithETag for a single recipe. Its ETag
+// starts with the recipe version, as in "3-9f86d081…", so clients can send
+// it back in If-Match to update the recipe they read.
+func (handler *RecipesHandler) respondRecipeWithETag(c *gin.Context, recipe models.Recipe) {
+	var version int64
+	if recipe.Version != nil {
+		version = *recipe.Version
+	}
+	handler.respondTagged(c, strconv.FormatInt(version, 10)+"-", recipe)
+}
+
+// respondTagged does the work of both, prefix leading the ETag.
+func (handler *RecipesHandler) respondTagged(c *gin.Context, prefix string, body interface{}) {
+	contentType := "application/json; charset=utf-8"
+	marshal := json.Marshal
+	if handler.wantsXML(c) {
+		contentType = "application/xml; charset=utf-8"
+		marshal = xml.Marshal
+	}
+	data, err := marshal(body)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + prefix + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// facetsTTL caps how long facets are cached, they are costly to compute but
+// should follow the recipes closely.
+const facetsTTL = time.Minute
+
+// swagger:operation GET /recipes/facets recipes recipeFacets
+// Returns the number of recipes per cuisine and per tag
+// ---
+// produces:
+// - application/json
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+func (handler *RecipesHandler) FacetsHandler(c *gin.Context) {
+	org := orgOf(c)
+	if handler.cacheTTL > 0 {
+		val, err := handler.redisClient.HGet("recipes:facets", org).Result()
+		if err != nil && err != redis.Nil {
+			respondDBError(c, err)
+			return
+		}
+		if err == nil {
+			var facets models.Facets
+			json.Unmarshal([]byte(val), &facets)
+			c.Header("X-Cache", "HIT")
+			c.JSON(http.StatusOK, facets)
+			return
+		}
+	}
+
+	countBy := func(field string) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{"_id": field, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"orgId": org, "deletedAt": notDeleted}}},
+		{{Key: "$facet", Value: bson.M{
+			"cuisines": append(bson.A{bson.M{"$match": bson.M{"cuisine": bson.M{"$type": "string"}}}}, countBy("$cuisine")...),
+			"tags":     append(bson.A{bson.M{"$unwind": "$tags"}}, countBy("$tags")...),
+		}}},
+	}
+	loggerFrom(c.Request.Context()).Debug("Request to MongoDB")
+	cur, err := handler.collection.Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(c.Request.Context())
+
+	facets := models.Facets{Cuisines: []models.FacetCount{}, Tags: []models.FacetCount{}}
+	if cur.Next(c.Request.Context()) {
+		if err := cur.Decode(&facets); err != nil {
+			respondDBError(c, err)
+			return
+		}
+	}
+
+	if handler.cacheTTL > 0 {
+		ttl := facetsTTL
+		if handler.cacheTTL < ttl {
+			ttl = handler.cacheTTL
+		}
+		data, _ := json.Marshal(facets)
+		handler.cacheVariant("recipes:facets", org, string(data), ttl)
+	}
+	c.Header("X-Cache", "MISS")
+	c.JSON(http.StatusOK, facets)
+}
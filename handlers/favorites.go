@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// favorites is the collection holding one {username, recipeId} document per
+// favorite, unique per pair.
+func (handler *RecipesHandler) favorites() *mongo.Collection {
+	return handler.collection.Database().Collection("favorites")
+}
+
+// setFavorite fills in whether the current user favorited recipe. It is best
+// effort: on failure, or while MongoDB is unreachable, the field is left out.
+func (handler *RecipesHandler) setFavorite(c *gin.Context, recipe *models.Recipe) {
+	if c.GetBool(degradedKey) {
+		return
+	}
+	user, _ := CurrentUser(c)
+	count, err := handler.favorites().CountDocuments(c.Request.Context(), bson.M{
+		"username": user.Username,
+		"recipeId": recipe.ID,
+	})
+	if err != nil {
+		loggerFrom(c.Request.Context()).Warn("Failed to read favorite", "error", err)
+		return
+	}
+	favorite := count > 0
+	recipe.Favorite = &favorite
+}
+
+// swagger:operation POST /recipes/{id}/favorite favorites favoriteRecipe
+// Add a recipe to the favorites of the current user
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The recipe is a favorite, also when it already was
+//	'400':
+//	    description: Invalid recipe ID
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) FavoriteRecipeHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	count, err := handler.collection.CountDocuments(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if count == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+
+	user, _ := CurrentUser(c)
+	username := user.Username
+	_, err = handler.favorites().UpdateOne(c.Request.Context(), bson.M{
+		"username": username,
+		"recipeId": objectId,
+	}, bson.M{
+		"$setOnInsert": bson.M{"createdAt": time.Now()},
+	}, options.Update().SetUpsert(true))
+	// two concurrent upserts can both insert, the unique index rejects one
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"favorite": true})
+}
+
+// swagger:operation DELETE /recipes/{id}/favorite favorites unfavoriteRecipe
+// Remove a recipe from the favorites of the current user
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The recipe is not a favorite, also when it wasn't one
+//	'400':
+//	    description: Invalid recipe ID
+func (handler *RecipesHandler) UnfavoriteRecipeHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	user, _ := CurrentUser(c)
+	_, err := handler.favorites().DeleteOne(c.Request.Context(), bson.M{
+		"username": user.Username,
+		"recipeId": objectId,
+	})
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"favorite": false})
+}
+
+// swagger:operation GET /recipes/favorites favorites listFavorites
+// Returns a page of the current user's favorite recipes, latest first
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: limit
+//     in: query
+//     description: number of recipes per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid query parameters
+func (handler *RecipesHandler) ListFavoritesHandler(c *gin.Context) {
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	// deleted recipes stay favorited, so they come back when restored, but
+	// are left out of the list, like those of other organizations for users
+	// who moved
+	user, _ := CurrentUser(c)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"username": user.Username}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         handler.collection.Name(),
+			"localField":   "recipeId",
+			"foreignField": "_id",
+			"as":           "recipe",
+		}}},
+		{{Key: "$unwind", Value: "$recipe"}},
+		{{Key: "$match", Value: bson.M{"recipe.orgId": orgOf(c), "recipe.deletedAt": notDeleted}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "count"}},
+			"data": bson.A{
+				bson.M{"$skip": (page - 1) * limit},
+				bson.M{"$limit": limit},
+				bson.M{"$replaceRoot": bson.M{"newRoot": "$recipe"}},
+			},
+		}}},
+	}
+	cur, err := handler.favorites().Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(c.Request.Context())
+
+	var result struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Data []models.Recipe `bson:"data"`
+	}
+	if cur.Next(c.Request.Context()) {
+		if err := cur.Decode(&result); err != nil {
+			respondDBError(c, err)
+			return
+		}
+	}
+
+	list := models.RecipeList{Data: result.Data, Page: page, Limit: limit}
+	if list.Data == nil {
+		list.Data = []models.Recipe{}
+	}
+	if len(result.Total) > 0 {
+		list.Total = result.Total[0].Count
+	}
+	list.TotalPages = (list.Total + limit - 1) / limit
+	favorite := true
+	for i := range list.Data {
+		list.Data[i].Favorite = &favorite
+	}
+	setPaginationLinks(c, list)
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// selectableFields maps the recipe fields clients can pick with ?fields= to
+// the fields of the stored documents.
+var selectableFields = map[string]string{
+	"id":           "_id",
+	"name":         "name",
+	"tags":         "tags",
+	"cuisine":      "cuisine",
+	"ingredients":  "ingredients",
+	"servings":     "servings",
+	"instructions": "instructions",
+	"difficulty":   "difficulty",
+	"prepTime":     "prepTime",
+	"cookTime":     "cookTime",
+	"publishedAt":  "publishedAt",
+	"createdAt":    "createdAt",
+	"updatedAt":    "updatedAt",
+	"owner":        "owner",
+	"deletedAt":    "deletedAt",
+	"image":        "image",
+	"images":       "images",
+	"version":      "version",
+	"avgRating":    "avgRating",
+	"ratingCount":  "ratingCount",
+	"views":        "views",
+}
+
+// fieldSelection is the set of recipe fields a client asked for, nil when it
+// wants all of them. The id is always part of it.
+type fieldSelection map[string]bool
+
+// parseFields reads a comma-separated list of recipe fields such as
+// "name,image".
+func parseFields(value string) (fieldSelection, error) {
+	if value == "" {
+		return nil, nil
+	}
+	fields := fieldSelection{"id": true}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := selectableFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+// projection is the MongoDB projection reading the selected fields, plus the
+// extra document fields the handler needs itself. It is nil when all fields
+// are selected.
+func (fields fieldSelection) projection(extra ...string) bson.M {
+	if fields == nil {
+		return nil
+	}
+	projection := bson.M{}
+	for field := range fields {
+		projection[selectableFields[field]] = 1
+	}
+	for _, field := range extra {
+		projection[field] = 1
+	}
+	return projection
+}
+
+// key is a canonical form of the selection to be used in cache keys.
+func (fields fieldSelection) key() string {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// apply restricts the recipes of a list body to the selected fields.
+func (fields fieldSelection) apply(body interface{}) interface{} {
+	if fields == nil {
+		return body
+	}
+	return selectedFields{body: body, fields: fields}
+}
+
+// selectedFields is a list body, such as a RecipeList, written with only the
+// selected fields of its recipes. The projection already left the others
+// empty, dropping them here keeps their zero values out of the response.
+type selectedFields struct {
+	body   interface{}
+	fields fieldSelection
+}
+
+func (selected selectedFields) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(selected.body)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	var recipes []map[string]json.RawMessage
+	if err := json.Unmarshal(body["data"], &recipes); err != nil {
+		return nil, err
+	}
+	for _, recipe := range recipes {
+		for field := range recipe {
+			if !selected.fields[field] {
+				delete(recipe, field)
+			}
+		}
+	}
+	if body["data"], err = json.Marshal(recipes); err != nil {
+		return nil, err
+	}
+	return json.Marshal(body)
+}
+
+// MarshalXML writes the body as usual, leaving out the elements of the
+// recipes, found at <recipes><data><recipe>, that weren't selected.
+func (selected selectedFields) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	data, err := xml.Marshal(selected.body)
+	if err != nil {
+		return err
+	}
+	const fieldDepth = 4
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth, skipped := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			if skipped == 0 && depth == fieldDepth && !selected.fields[element.Name.Local] {
+				skipped = depth
+			}
+		case xml.EndElement:
+			depth--
+			if skipped == depth+1 {
+				skipped = 0
+				continue
+			}
+		}
+		if skipped == 0 {
+			if err := e.EncodeToken(xml.CopyToken(token)); err != nil {
+				return err
+			}
+		}
+	}
+	return e.Flush()
+}
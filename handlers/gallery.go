@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+const maxGalleryImages = 10
+
+// swagger:operation POST /recipes/{id}/images recipes addGalleryImage
+// Add an image to the gallery of a recipe as a multipart "image" field (JPEG or PNG, up to 5MB)
+//
+// The image goes last in the gallery, the first image being the primary one.
+// A recipe holds up to 10 images.
+// ---
+// consumes:
+// - multipart/form-data
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: image
+//     in: formData
+//     description: JPEG or PNG image
+//     required: true
+//     type: file
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'201':
+//	    description: The gallery with the new image
+//	'400':
+//	    description: Missing, oversized or non-image upload
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: Recipe not found
+//	'409':
+//	    description: The gallery is full
+func (handler *ImagesHandler) AddGalleryImageHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	if !handler.recipes.authorizeOwner(c, objectId) {
+		return
+	}
+
+	header, contentType, extension, ok := readImageUpload(c)
+	if !ok {
+		return
+	}
+	imageId := primitive.NewObjectID().Hex()
+	image := models.Image{
+		ID:          imageId,
+		File:        id + "-" + imageId + extension,
+		ContentType: contentType,
+		URL:         fmt.Sprintf("%s/recipes/%s/images/%s", handler.basePath, id, imageId),
+	}
+	if !handler.saveImage(c, header, image.File) {
+		return
+	}
+
+	// the limit is part of the filter so concurrent uploads can't exceed it
+	var recipe models.Recipe
+	err := handler.recipes.collection.FindOneAndUpdate(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+		fmt.Sprintf("images.%d", maxGalleryImages-1): bson.M{"$exists": false},
+	}), bson.M{
+		"$push": bson.M{"images": image},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"images": 1})).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		handler.removeImage(c, image.File)
+		// the recipe exists, authorizeOwner checked it, unless it was
+		// deleted in the meantime
+		if handler.recipes.recipeExists(c, objectId) {
+			respondError(c, http.StatusConflict, "gallery_full", fmt.Sprintf("A recipe has at most %d images", maxGalleryImages))
+		}
+		return
+	}
+	if err != nil {
+		handler.removeImage(c, image.File)
+		respondDBError(c, err)
+		return
+	}
+
+	handler.recipes.invalidateCache(id)
+	c.JSON(http.StatusCreated, recipe.Images)
+}
+
+// swagger:operation GET /recipes/{id}/images/{imageId} recipes getGalleryImage
+// Download an image of the gallery of a recipe
+// ---
+// produces:
+// - image/jpeg
+// - image/png
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: imageId
+//     in: path
+//     description: ID of the image
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid recipe ID
+//	'404':
+//	    description: Recipe or image not found
+func (handler *ImagesHandler) GetGalleryImageHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var recipe models.Recipe
+	err := handler.recipes.collection.FindOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+		"images.id": c.Param("imageId"),
+	}), options.FindOne().SetProjection(bson.M{"images.$": 1})).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Image not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	image := recipe.Images[0]
+	c.Header("Content-Type", image.ContentType)
+	c.File(filepath.Join(handler.dir, image.File))
+}
+
+// swagger:operation DELETE /recipes/{id}/images/{imageId} recipes deleteGalleryImage
+// Remove an image from the gallery of a recipe
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: imageId
+//     in: path
+//     description: ID of the image
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The remaining gallery
+//	'400':
+//	    description: Invalid recipe ID
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: Recipe or image not found
+func (handler *ImagesHandler) DeleteGalleryImageHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	if !handler.recipes.authorizeOwner(c, objectId) {
+		return
+	}
+
+	imageId := c.Param("imageId")
+	var previous models.Recipe
+	err := handler.recipes.collection.FindOneAndUpdate(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+		"images.id": imageId,
+	}), bson.M{
+		"$pull": bson.M{"images": bson.M{"id": imageId}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}, options.FindOneAndUpdate().SetProjection(bson.M{"images": 1})).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Image not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	images := make([]models.Image, 0, len(previous.Images))
+	for _, image := range previous.Images {
+		if image.ID == imageId {
+			handler.removeImage(c, image.File)
+			continue
+		}
+		images = append(images, image)
+	}
+	handler.recipes.invalidateCache(id)
+	c.JSON(http.StatusOK, images)
+}
+
+// swagger:operation PUT /recipes/{id}/images/order recipes orderGalleryImages
+// Reorder the gallery of a recipe
+//
+// The body lists the IDs of all the images in their new order, the first one
+// becomes the primary image.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     description: New order, the first image becomes the primary one
+//     required: true
+//     schema: {$ref: '#/definitions/GalleryOrder'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The reordered gallery
+//	'400':
+//	    description: Invalid recipe ID, or the IDs don't match the gallery
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: Recipe not found
+//	'409':
+//	    description: The gallery changed meanwhile
+func (handler *ImagesHandler) OrderGalleryImagesHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	var body struct {
+		Order []string `json:"order" binding:"required"`
+	}
+	if !bindJSON(c, &body) {
+		return
+	}
+	if !handler.recipes.authorizeOwner(c, objectId) {
+		return
+	}
+
+	var recipe models.Recipe
+	err := handler.recipes.collection.FindOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	}), options.FindOne().SetProjection(bson.M{"images": 1})).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	byId := make(map[string]models.Image, len(recipe.Images))
+	for _, image := range recipe.Images {
+		byId[image.ID] = image
+	}
+	images := make([]models.Image, 0, len(body.Order))
+	for _, imageId := range body.Order {
+		image, found := byId[imageId]
+		if !found {
+			respondError(c, http.StatusBadRequest, "bad_request", "order must list every image of the gallery exactly once")
+			return
+		}
+		delete(byId, imageId)
+		images = append(images, image)
+	}
+	if len(byId) > 0 {
+		respondError(c, http.StatusBadRequest, "bad_request", "order must list every image of the gallery exactly once")
+		return
+	}
+
+	// matching the gallery as it was read makes sure no image was added or
+	// removed in between
+	result, err := handler.recipes.collection.UpdateOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+		"images":    recipe.Images,
+	}), bson.M{"$set": bson.M{"images": images, "updatedAt": time.Now()}})
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		respondError(c, http.StatusConflict, "gallery_conflict", "The gallery changed, fetch it again")
+		return
+	}
+
+	handler.recipes.invalidateCache(id)
+	c.JSON(http.StatusOK, images)
+}
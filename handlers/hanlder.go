@@ -2,8 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,100 +18,620 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"golang.org/x/net/context"
 
 	"github.com/Jovdza012/gin_chapter_2/models"
 )
 
+const (
+	defaultPage  = 1
+	defaultLimit = 20
+
+	// maxStaleness is the lowest max staleness MongoDB accepts
+	maxStaleness = 90 * time.Second
+)
+
 type RecipesHandler struct {
-	collection  *mongo.Collection
-	ctx         context.Context
-	redisClient *redis.Client
+	collection *mongo.Collection
+	// listCollection serves the list and search queries. It reads from the
+	// secondaries when they are enabled and is collection otherwise.
+	listCollection *mongo.Collection
+	ctx            context.Context
+	redisClient    *redis.Client
+	cacheTTL       time.Duration
+	schema         *RecipeSchema
+	xmlOutput      bool
+	// duplicateThreshold is the fraction of its ingredients a new recipe
+	// must share with one of the same name to be rejected as a duplicate
+	duplicateThreshold float64
+	maxLimit           int64
+	// closing is closed by CloseStreams to end the event streams
+	closing      chan struct{}
+	closeStreams sync.Once
 }
 
-func NewRecipesHandler(ctx context.Context, collection *mongo.Collection, redisClient *redis.Client) *RecipesHandler {
+// NewRecipesHandler creates the recipes handler. Cached reads expire after
+// cacheTTL, a zero cacheTTL disables the cache and always reads MongoDB.
+// Created and replaced recipes must also satisfy schema unless it is nil.
+// With xmlOutput, recipes are also written as XML to clients asking for it.
+// A new recipe sharing duplicateThreshold of its ingredients with one of the
+// same name is refused as a duplicate. Pages never hold more than maxLimit
+// items, larger limits are clamped. With readSecondary, lists and searches
+// are read from the secondaries of the replica set when one is available.
+func NewRecipesHandler(ctx context.Context, collection *mongo.Collection, redisClient *redis.Client, cacheTTL time.Duration, schema *RecipeSchema, xmlOutput bool, duplicateThreshold float64, maxLimit int64, readSecondary bool) *RecipesHandler {
+	listCollection := collection
+	if readSecondary {
+		// secondaries lagging behind by more than maxStaleness are skipped
+		secondary := readpref.SecondaryPreferred(readpref.WithMaxStaleness(maxStaleness))
+		clone, err := collection.Clone(options.Collection().SetReadPreference(secondary))
+		if err != nil {
+			slog.Warn("Failed to read lists from secondaries, using the primary", "error", err)
+		} else {
+			listCollection = clone
+		}
+	}
 	return &RecipesHandler{
-		collection:  collection,
-		ctx:         ctx,
-		redisClient: redisClient,
+		collection:         collection,
+		listCollection:     listCollection,
+		ctx:                ctx,
+		redisClient:        redisClient,
+		cacheTTL:           cacheTTL,
+		schema:             schema,
+		xmlOutput:          xmlOutput,
+		duplicateThreshold: duplicateThreshold,
+		maxLimit:           maxLimit,
+		closing:            make(chan struct{}),
 	}
 }
 
 // swagger:operation GET /recipes recipes listRecipes
-// Returns list of recipes
+// Returns a page of recipes
+//
+// Pages are numbered with page, or read in turn with cursor for stable
+// paging: an empty cursor returns the first page along with the nextCursor
+// of the following one, which is left out on the last page. With
+// READ_SECONDARY set, lists read from the secondaries and may miss recipes
+// for a moment after they are written.
 // ---
 // produces:
 // - application/json
+// - application/xml
+// parameters:
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: cursor
+//     in: query
+//     description: nextCursor of the previous page, empty for the first one; lists in cursor mode, which doesn't skip or repeat recipes when the list changes between pages
+//     required: false
+//     type: string
+//   - name: limit
+//     in: query
+//     description: number of recipes per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//   - name: tag
+//     in: query
+//     description: tag to filter by, can be repeated
+//     required: false
+//     type: array
+//     items: {type: string}
+//     collectionFormat: multi
+//   - name: match
+//     in: query
+//     description: whether recipes must have all or any of the tags (default any)
+//     required: false
+//     type: string
+//     enum: [all, any]
+//   - name: mine
+//     in: query
+//     description: only return recipes created by the current user
+//     required: false
+//     type: boolean
+//   - name: cuisine
+//     in: query
+//     description: only return recipes of this cuisine
+//     required: false
+//     type: string
+//   - name: difficulty
+//     in: query
+//     description: only return recipes of this difficulty
+//     required: false
+//     type: string
+//     enum: [easy, medium, hard]
+//   - name: maxPrep
+//     in: query
+//     description: only return recipes taking at most this many minutes to prepare
+//     required: false
+//     type: integer
+//   - name: maxCook
+//     in: query
+//     description: only return recipes taking at most this many minutes to cook
+//     required: false
+//     type: integer
+//   - name: maxTotal
+//     in: query
+//     description: only return recipes whose preparation and cooking take at most this many minutes together
+//     required: false
+//     type: integer
+//   - name: sort
+//     in: query
+//     description: sort order, prefix with - for descending
+//     required: false
+//     type: string
+//     enum: [name, -name, publishedAt, -publishedAt, avgRating, -avgRating]
+//   - name: fields
+//     in: query
+//     description: comma-separated recipe fields to return, such as name,image; the id is always returned
+//     required: false
+//     type: array
+//     items: {type: string}
+//     collectionFormat: csv
+//   - name: includeDeleted
+//     in: query
+//     description: also list deleted recipes, admins only
+//     required: false
+//     type: boolean
+//   - name: modifiedSince
+//     in: query
+//     description: RFC 3339 time, only return the changes since then, all other parameters are ignored
+//     required: false
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
 // responses:
 //
 //	'200':
 //	    description: Successful operation
+//	'304':
+//	    description: Page unchanged since the ETag sent in If-None-Match
+//	'400':
+//	    description: Invalid query parameters, unknown field or invalid cursor, or page and cursor combined
+//	'403':
+//	    description: includeDeleted used by a non-admin
 func (handler *RecipesHandler) ListRecipesHandler(c *gin.Context) {
-	val, err := handler.redisClient.Get("recipes").Result()
-	if err == redis.Nil {
-		log.Printf("Request to MongoDB")
-		cur, err := handler.collection.Find(handler.ctx, bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if value := c.Query("modifiedSince"); value != "" {
+		if databaseUnavailable(c) {
 			return
 		}
-		defer cur.Close(handler.ctx)
+		handler.syncRecipes(c, value)
+		return
+	}
+	if c.Query("includeDeleted") == "true" && !hasRole(c, "admin") {
+		respondError(c, http.StatusForbidden, "forbidden", "Only admins can list deleted recipes")
+		return
+	}
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	_, cursorMode := c.GetQuery("cursor")
+	if _, paged := c.GetQuery("page"); cursorMode && paged {
+		respondError(c, http.StatusBadRequest, "bad_request", "page and cursor cannot be combined")
+		return
+	}
+	filter, filterKey, err := parseRecipeFilter(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	sortValue := c.Query("sort")
+	sortDoc, err := parseSort(sortValue)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	fields, err := parseFields(c.Query("fields"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if cursorMode {
+		handler.listAfterCursor(c, filter, filterKey, sortValue, sortDoc, fields, limit)
+		return
+	}
 
-		recipes := make([]models.Recipe, 0)
-		for cur.Next(handler.ctx) {
-			var recipe models.Recipe
-			cur.Decode(&recipe)
-			recipes = append(recipes, recipe)
+	// every variant of the list lives in the "recipes" hash so a single
+	// Del("recipes") invalidates all of them
+	cacheField := fmt.Sprintf("page=%d:limit=%d:sort=%s:fields=%s:%s", page, limit, sortValue, fields.key(), filterKey)
+	if handler.cacheTTL > 0 {
+		val, found, unlock, err := handler.cacheLookup(c.Request.Context(), "recipes:"+cacheField, func() (string, error) {
+			return handler.redisClient.HGet("recipes", cacheField).Result()
+		})
+		if err != nil {
+			loggerFrom(c.Request.Context()).Warn("Failed to read recipes from cache", "error", err)
+		}
+		defer unlock()
+		if found {
+			loggerFrom(c.Request.Context()).Debug("Request to Redis")
+			var list models.RecipeList
+			json.Unmarshal([]byte(val), &list)
+			c.Header("X-Cache", "HIT")
+			setPaginationLinks(c, list)
+			handler.respondWithETag(c, fields.apply(list))
+			return
 		}
+	}
 
-		data, _ := json.Marshal(recipes)
-		handler.redisClient.Set("recipes", string(data), 0)
-		c.JSON(http.StatusOK, recipes)
-	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if databaseUnavailable(c) {
 		return
-	} else {
-		log.Printf("Request to Redis")
-		recipes := make([]models.Recipe, 0)
-		json.Unmarshal([]byte(val), &recipes)
-		c.JSON(http.StatusOK, recipes)
+	}
+	loggerFrom(c.Request.Context()).Debug("Request to MongoDB")
+	opts := options.Find().SetSort(sortDoc)
+	if projection := fields.projection(); projection != nil {
+		opts.SetProjection(projection)
+	}
+	list, err := findPage(c.Request.Context(), handler.listCollection, filter, opts, page, limit)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	if handler.cacheTTL > 0 {
+		data, _ := json.Marshal(fields.apply(list))
+		handler.cacheListVariant(cacheField, string(data))
+	}
+	c.Header("X-Cache", "MISS")
+	setPaginationLinks(c, list)
+	handler.respondWithETag(c, fields.apply(list))
+}
+
+// cacheListVariant stores one variant of the recipe list in the "recipes"
+// hash.
+func (handler *RecipesHandler) cacheListVariant(field, data string) {
+	handler.cacheVariant("recipes", field, data, handler.cacheTTL)
+}
+
+// cacheVariant stores one variant of a cached read, such as the copy of a
+// recipe seen by one organization, as a field of the hash at key so deleting
+// key invalidates all of them. Redis can only expire the hash as a whole, so
+// the TTL is set when the hash is created: no variant is ever served more
+// than ttl later.
+func (handler *RecipesHandler) cacheVariant(key, field, data string, ttl time.Duration) {
+	pipe := handler.redisClient.TxPipeline()
+	pipe.HSet(key, field, data)
+	current := pipe.TTL(key)
+	if _, err := pipe.Exec(); err != nil {
+		slog.Warn("Failed to cache recipes", "key", key, "error", err)
+		return
+	}
+	if current.Val() < 0 {
+		handler.redisClient.Expire(key, ttl)
+	}
+}
+
+// findPage runs filter on collection with the given find options restricted
+// to one page and wraps the result together with the total number of
+// matching recipes.
+func findPage(ctx context.Context, collection *mongo.Collection, filter bson.M, opts *options.FindOptions, page, limit int64) (models.RecipeList, error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return models.RecipeList{}, err
+	}
+
+	opts.SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return models.RecipeList{}, err
+	}
+	defer cur.Close(ctx)
+
+	recipes := make([]models.Recipe, 0)
+	for cur.Next(ctx) {
+		var recipe models.Recipe
+		cur.Decode(&recipe)
+		recipes = append(recipes, recipe)
+	}
+
+	return models.RecipeList{
+		Data:       recipes,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: (total + limit - 1) / limit,
+	}, nil
+}
+
+// parseRecipeFilter builds the MongoDB filter for the list query parameters,
+// within the organization of the request. It also returns a canonical form of
+// the filter to be used in cache keys.
+func parseRecipeFilter(c *gin.Context) (bson.M, string, error) {
+	filter := inOrg(c, bson.M{})
+
+	tags := make([]string, 0)
+	for _, tag := range c.QueryArray("tag") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	match := c.DefaultQuery("match", "any")
+	if match != "any" && match != "all" {
+		return nil, "", errors.New("match must be either all or any")
+	}
+	if len(tags) > 0 {
+		operator := "$in"
+		if match == "all" {
+			operator = "$all"
+		}
+		filter["tags"] = bson.M{operator: tags}
+	}
+
+	includeDeleted := c.Query("includeDeleted") == "true"
+	if !includeDeleted {
+		filter["deletedAt"] = notDeleted
+	}
+
+	mine := ""
+	if c.Query("mine") == "true" {
+		user, _ := CurrentUser(c)
+		mine = user.Username
+		filter["owner"] = mine
+	}
+
+	cuisine := strings.TrimSpace(c.Query("cuisine"))
+	if cuisine != "" {
+		filter["cuisine"] = cuisine
+	}
+
+	difficulty := c.Query("difficulty")
+	if difficulty != "" {
+		if !difficulties[difficulty] {
+			return nil, "", errors.New("difficulty must be one of easy, medium or hard")
+		}
+		filter["difficulty"] = difficulty
+	}
+
+	// recipes without a time never match a maximum on it
+	times := make([]string, 0, 3)
+	for _, param := range []string{"maxPrep", "maxCook", "maxTotal"} {
+		value := c.Query(param)
+		if value == "" {
+			times = append(times, "")
+			continue
+		}
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			return nil, "", fmt.Errorf("%s must be a non-negative number of minutes", param)
+		}
+		times = append(times, strconv.Itoa(minutes))
+		switch param {
+		case "maxPrep":
+			filter["prepTime"] = bson.M{"$lte": minutes}
+		case "maxCook":
+			filter["cookTime"] = bson.M{"$lte": minutes}
+		case "maxTotal":
+			// a missing time counts as none, as long as the other one is set
+			filter["$and"] = bson.A{
+				bson.M{"$or": bson.A{bson.M{"prepTime": bson.M{"$gt": 0}}, bson.M{"cookTime": bson.M{"$gt": 0}}}},
+				bson.M{"$expr": bson.M{"$lte": bson.A{
+					bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$prepTime", 0}}, bson.M{"$ifNull": bson.A{"$cookTime", 0}}}},
+					minutes,
+				}}},
+			}
+		}
+	}
+
+	return filter, fmt.Sprintf("org=%s:tags=%s:match=%s:mine=%s:deleted=%t:cuisine=%s:difficulty=%s:maxPrep=%s:maxCook=%s:maxTotal=%s",
+		filter["orgId"], strings.Join(tags, ","), match, mine, includeDeleted, cuisine, difficulty, times[0], times[1], times[2]), nil
+}
+
+// difficulties are the allowed values of the difficulty of a recipe.
+var difficulties = map[string]bool{
+	"easy":   true,
+	"medium": true,
+	"hard":   true,
+}
+
+// authorizeOwner lets the request through when the current user owns the
+// recipe or is an admin, otherwise it answers 404 or 403 and returns false.
+// Recipes of other organizations are not found.
+func (handler *RecipesHandler) authorizeOwner(c *gin.Context, objectId primitive.ObjectID) bool {
+	var recipe models.Recipe
+	err := handler.collection.FindOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id": objectId,
+	}), options.FindOne().SetProjection(bson.M{"owner": 1})).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return false
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return false
+	}
+
+	if user, _ := CurrentUser(c); recipe.Owner != user.Username && !hasRole(c, "admin") {
+		respondError(c, http.StatusForbidden, "forbidden", "You are not the owner of this recipe")
+		return false
+	}
+	return true
+}
+
+// initialVersion is the version of newly created recipes.
+const initialVersion int64 = 1
+
+// notDeleted matches recipes that haven't been soft deleted.
+var notDeleted = bson.M{"$exists": false}
+
+// sortableFields are the recipe fields the list can be sorted on.
+var sortableFields = map[string]bool{
+	"name":        true,
+	"publishedAt": true,
+	"avgRating":   true,
+}
+
+// parseSort maps a sort parameter such as "name" or "-publishedAt" to a sort
+// document. The id is always appended as a tie-breaker so pages are stable.
+func parseSort(value string) (bson.D, error) {
+	if value == "" {
+		return bson.D{{Key: "_id", Value: 1}}, nil
+	}
+
+	field, order := value, 1
+	if strings.HasPrefix(value, "-") {
+		field, order = value[1:], -1
+	}
+	if !sortableFields[field] {
+		return nil, fmt.Errorf("cannot sort by %q", field)
+	}
+	return bson.D{{Key: field, Value: order}, {Key: "_id", Value: 1}}, nil
+}
+
+// parseObjectID converts a path id to an ObjectID, answering 400 when it
+// isn't one so that malformed ids never reach MongoDB.
+func parseObjectID(c *gin.Context, id string) (primitive.ObjectID, bool) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_id", "invalid id")
+		return primitive.NilObjectID, false
+	}
+	return objectId, true
+}
+
+// parsePagination reads the page and limit query parameters, falling back to
+// defaultPage and defaultLimit when they are absent. A limit above maxLimit
+// is lowered to it rather than refused, with an X-Limit-Clamped header
+// telling the client it got fewer items than it asked for.
+func parsePagination(c *gin.Context, maxLimit int64) (int64, int64, error) {
+	page, err := strconv.ParseInt(c.DefaultQuery("page", strconv.Itoa(defaultPage)), 10, 64)
+	if err != nil || page < 1 {
+		return 0, 0, errors.New("page must be a positive integer")
+	}
+	value, ok := c.GetQuery("limit")
+	if !ok {
+		return page, min(defaultLimit, maxLimit), nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 1 {
+		return 0, 0, errors.New("limit must be a positive integer")
+	}
+	if limit > maxLimit {
+		c.Header("X-Limit-Clamped", "true")
+		limit = maxLimit
+	}
+	return page, limit, nil
+}
+
+// invalidateCache drops every cached recipe list and the facets along with
+// the cached copies and nutrition facts of the given recipes, so the next read
+// repopulates them from MongoDB.
+func (handler *RecipesHandler) invalidateCache(ids ...string) {
+	keys := []string{"recipes", "recipes:facets"}
+	for _, id := range ids {
+		keys = append(keys, "recipe:"+id, "nutrition:"+id)
+	}
+	slog.Debug("Remove data from Redis")
+	if err := handler.redisClient.Del(keys...).Err(); err != nil {
+		slog.Error("Failed to invalidate cache", "error", err)
 	}
 }
 
 // swagger:operation POST /recipes recipes newRecipe
 // Create a new recipe
+//
+// Retrying with the same Idempotency-Key and payload returns the recipe
+// created by the first request instead of creating another one. A recipe
+// with the same name as an existing one, ignoring case, and mostly the same
+// ingredients is refused as a duplicate unless force is set.
 // ---
 // produces:
 // - application/json
+// - application/xml
+// parameters:
+//   - name: Idempotency-Key
+//     in: header
+//     description: unique key making the request safe to retry for 24 hours
+//     required: false
+//     type: string
+//   - name: force
+//     in: query
+//     description: create the recipe even when it looks like a duplicate
+//     required: false
+//     type: boolean
+//   - name: body
+//     in: body
+//     description: Recipe to create
+//     required: true
+//     schema: {$ref: '#/definitions/Recipe'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
 // responses:
 //
 //	'200':
 //	    description: Successful operation
 //	'400':
 //	    description: Invalid input
+//	'409':
+//	    description: Likely duplicate of an existing recipe, or a request with the same Idempotency-Key is still running
+//	'422':
+//	    description: Idempotency-Key already used with a different payload
 func (handler *RecipesHandler) NewRecipeHandler(c *gin.Context) {
+	idem, ok := handler.startIdempotent(c)
+	if !ok {
+		return
+	}
 	var recipe models.Recipe
-	if err := c.ShouldBindJSON(&recipe); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !handler.bindRecipe(c, &recipe) {
+		idem.abandon()
 		return
 	}
+	recipe.OrgID = orgOf(c)
+	if c.Query("force") != "true" {
+		existing, err := handler.findDuplicate(c.Request.Context(), recipe)
+		if err != nil {
+			idem.abandon()
+			respondDBError(c, err)
+			return
+		}
+		if existing != nil {
+			idem.abandon()
+			respondDuplicate(c, existing)
+			return
+		}
+	}
 
 	recipe.ID = primitive.NewObjectID()
 	recipe.PublishedAt = time.Now()
-	_, err := handler.collection.InsertOne(handler.ctx, recipe)
+	recipe.CreatedAt = recipe.PublishedAt
+	recipe.UpdatedAt = recipe.PublishedAt
+	user, _ := CurrentUser(c)
+	recipe.Owner = user.Username
+	version := initialVersion
+	recipe.Version = &version
+	recipe.AvgRating, recipe.RatingCount, recipe.Views = 0, 0, 0
+	recipe.Images = nil
+	_, err := handler.collection.InsertOne(c.Request.Context(), recipe)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting a new recipe"})
+		idem.abandon()
+		respondError(c, http.StatusInternalServerError, "internal_error", "Error while inserting a new recipe")
 		return
 	}
 
-	log.Println("Remove data from Redis")
-	handler.redisClient.Del("recipes")
+	handler.invalidateCache(recipe.ID.Hex())
+	handler.audit(c, "create", recipe.ID, recipeSnapshot(recipe))
 
-	c.JSON(http.StatusOK, recipe)
+	idem.complete(http.StatusOK, recipe)
+	handler.respond(c, http.StatusOK, recipe)
 }
 
 // swagger:operation PUT /recipes/{id} recipes updateRecipe
-// Update an existing recipe
+// Update an existing recipe. The version the client last read must be sent
+// in the If-Match header or the version field, so concurrent edits are
+// detected instead of silently overwriting each other.
 // ---
 // parameters:
 //   - name: id
@@ -112,49 +639,161 @@ func (handler *RecipesHandler) NewRecipeHandler(c *gin.Context) {
 //     description: ID of the recipe
 //     required: true
 //     type: string
+//   - name: If-Match
+//     in: header
+//     description: ETag or version of the recipe as last read
+//     required: false
+//     type: string
+//   - name: body
+//     in: body
+//     description: Updated recipe
+//     required: true
+//     schema: {$ref: '#/definitions/Recipe'}
 //
 // produces:
 // - application/json
+// - application/xml
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
 // responses:
 //
 //	'200':
 //	    description: Successful operation
 //	'400':
 //	    description: Invalid input
+//	'403':
+//	    description: Not the owner of the recipe
 //	'404':
-//	    description: Invalid recipe ID
+//	    description: Recipe not found
+//	'409':
+//	    description: The recipe was modified since the expected version
+//	'428':
+//	    description: Expected version missing
 func (handler *RecipesHandler) UpdateRecipeHandler(c *gin.Context) {
 	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
 	var recipe models.Recipe
-	if err := c.ShouldBindJSON(&recipe); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !handler.bindRecipe(c, &recipe) {
+		return
+	}
+	version, ok := expectedVersion(c, recipe)
+	if !ok {
+		return
+	}
+	if !handler.authorizeOwner(c, objectId) {
 		return
 	}
 
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	_, err := handler.collection.UpdateOne(handler.ctx, bson.M{
-		"_id": objectId,
-	}, bson.D{{Key: "$set", Value: bson.D{
+	updatedAt := time.Now()
+	set := bson.D{
 		{Key: "name", Value: recipe.Name},
 		{Key: "instructions", Value: recipe.Instructions},
 		{Key: "ingredients", Value: recipe.Ingredients},
 		{Key: "tags", Value: recipe.Tags},
-	}}})
+		{Key: "cuisine", Value: recipe.Cuisine},
+		{Key: "servings", Value: recipe.Servings},
+		{Key: "updatedAt", Value: updatedAt},
+	}
+	// the times and difficulty left out are removed rather than stored as
+	// zero, which the time filters would take for a quick recipe
+	var unset bson.D
+	for _, field := range []bson.E{
+		{Key: "difficulty", Value: recipe.Difficulty},
+		{Key: "prepTime", Value: recipe.PrepTime},
+		{Key: "cookTime", Value: recipe.CookTime},
+	} {
+		if field.Value == "" || field.Value == 0 {
+			unset = append(unset, bson.E{Key: field.Key, Value: ""})
+		} else {
+			set = append(set, field)
+		}
+	}
+	update := bson.D{{Key: "$set", Value: set}, {Key: "$inc", Value: bson.M{"version": 1}}}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	result, err := handler.collection.UpdateOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+		"version":   versionFilter(version),
+	}), update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondDBError(c, err)
 		return
 	}
+	if result.MatchedCount == 0 {
+		handler.versionMismatch(c, objectId)
+		return
+	}
+
+	handler.invalidateCache(id)
+	handler.audit(c, "update", objectId, recipeSnapshot(recipe))
 
-	handler.redisClient.Del("recipes")
+	handler.respond(c, http.StatusOK, gin.H{"message": "Recipe has been updated", "version": version + 1, "updatedAt": updatedAt})
+}
+
+// expectedVersion reads the version the client expects to update, from the
+// If-Match header or else from the body. If-Match takes the ETag of
+// GetOneRecipeHandler or the bare version. It answers 428 when neither is
+// set.
+func expectedVersion(c *gin.Context, recipe models.Recipe) (int64, bool) {
+	if header := c.GetHeader("If-Match"); header != "" {
+		tag, _, _ := strings.Cut(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), "-")
+		version, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil || version < 0 {
+			respondError(c, http.StatusBadRequest, "bad_request", "If-Match must hold the ETag or version of the recipe")
+			return 0, false
+		}
+		return version, true
+	}
+	if recipe.Version != nil {
+		return *recipe.Version, true
+	}
+	respondError(c, http.StatusPreconditionRequired, "precondition_required", "The expected version must be sent in If-Match or the version field")
+	return 0, false
+}
+
+// versionFilter matches the expected version. Recipes created before
+// versioning have no version field and count as version 0.
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Recipe has been updated"})
+// versionMismatch explains why a versioned update matched nothing: either the
+// recipe is gone or somebody else updated it first.
+func (handler *RecipesHandler) versionMismatch(c *gin.Context, objectId primitive.ObjectID) {
+	count, err := handler.collection.CountDocuments(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if count == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+	respondError(c, http.StatusConflict, "version_conflict", "Recipe has been modified by someone else, reload it and try again")
 }
 
 // swagger:operation DELETE /recipes/{id} recipes deleteRecipe
-// Delete an existing recipe
+// Delete an existing recipe. The recipe is only flagged as deleted and can
+// be restored later.
 // ---
 // produces:
 // - application/json
+// - application/xml
 // parameters:
 //   - name: id
 //     in: path
@@ -162,89 +801,431 @@ func (handler *RecipesHandler) UpdateRecipeHandler(c *gin.Context) {
 //     required: true
 //     type: string
 //
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
 // responses:
 //
 //	'200':
 //	    description: Successful operation
-//	'404':
+//	'400':
 //	    description: Invalid recipe ID
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: Recipe not found
 func (handler *RecipesHandler) DeleteRecipeHandler(c *gin.Context) {
 	id := c.Param("id")
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	_, err := handler.collection.DeleteOne(handler.ctx, bson.M{
-		"_id": objectId,
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	if !handler.authorizeOwner(c, objectId) {
+		return
+	}
+	result, err := handler.collection.UpdateOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	}), bson.M{"$set": bson.M{"deletedAt": time.Now()}})
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+
+	handler.invalidateCache(id)
+	handler.audit(c, "delete", objectId, nil)
+
+	handler.respond(c, http.StatusOK, gin.H{"message": "Recipe has been deleted"})
+}
+
+// swagger:operation POST /recipes/{id}/restore recipes restoreRecipe
+// Restore a deleted recipe
+// ---
+// produces:
+// - application/json
+// - application/xml
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid recipe ID
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: No deleted recipe with this ID
+func (handler *RecipesHandler) RestoreRecipeHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	if !handler.authorizeOwner(c, objectId) {
+		return
+	}
+	result, err := handler.collection.UpdateOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": bson.M{"$exists": true},
+	}), bson.M{
+		"$unset": bson.M{"deletedAt": ""},
+		// restoring is a change syncing clients have to pick up
+		"$set": bson.M{"updatedAt": time.Now()},
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondDBError(c, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe is not deleted")
 		return
 	}
 
-	handler.redisClient.Del("recipes")
+	handler.invalidateCache(id)
+	handler.audit(c, "restore", objectId, nil)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Recipe has been deleted"})
+	handler.respond(c, http.StatusOK, gin.H{"message": "Recipe has been restored"})
 }
 
-// swagger:operation GET /recipes/{id} recipes
+// swagger:operation DELETE /recipes/{id}/permanent recipes purgeRecipe
+// Permanently delete a recipe, admins only
+// ---
+// produces:
+// - application/json
+// - application/xml
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid recipe ID
+//	'403':
+//	    description: Not an admin
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) PurgeRecipeHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	result, err := handler.collection.DeleteOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id": objectId,
+	}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+
+	if _, err := handler.favorites().DeleteMany(c.Request.Context(), bson.M{"recipeId": objectId}); err != nil {
+		loggerFrom(c.Request.Context()).Warn("Failed to delete favorites of purged recipe", "error", err)
+	}
+	if _, err := handler.ratings().DeleteMany(c.Request.Context(), bson.M{"recipeId": objectId}); err != nil {
+		loggerFrom(c.Request.Context()).Warn("Failed to delete ratings of purged recipe", "error", err)
+	}
+	if _, err := handler.comments().DeleteMany(c.Request.Context(), bson.M{"recipeId": objectId}); err != nil {
+		loggerFrom(c.Request.Context()).Warn("Failed to delete comments of purged recipe", "error", err)
+	}
+	if _, err := handler.tombstones().InsertOne(c.Request.Context(), bson.M{"recipeId": objectId, "orgId": orgOf(c), "deletedAt": time.Now()}); err != nil {
+		loggerFrom(c.Request.Context()).Error("Failed to record purged recipe for sync", "error", err)
+	}
+	handler.invalidateCache(id)
+	handler.audit(c, "purge", objectId, nil)
+
+	handler.respond(c, http.StatusOK, gin.H{"message": "Recipe has been permanently deleted"})
+}
+
+// swagger:operation GET /recipes/{id} recipes getRecipe
 // Get one recipe
+//
+// GET /recipes/{id}.txt, or Accept: text/plain, returns it as printable text.
 // ---
 // produces:
 // - application/json
+// - application/xml
+// - text/plain
 // parameters:
 //   - name: id
 //     in: path
 //     description: recipe ID
 //     required: true
 //     type: string
+//   - name: servings
+//     in: query
+//     description: scale the ingredient quantities to this many servings
+//     required: false
+//     type: integer
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
 //
 // responses:
 //
 //	'200':
 //	    description: Successful operation
+//	'304':
+//	    description: Recipe unchanged since the ETag sent in If-None-Match
+//	'400':
+//	    description: Invalid recipe ID or servings
+//	'404':
+//	    description: Recipe not found
+//	'422':
+//	    description: servings sent for a recipe without servings
 func (handler *RecipesHandler) GetOneRecipeHandler(c *gin.Context) {
-	id := c.Param("id")
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	cur := handler.collection.FindOne(handler.ctx, bson.M{
-		"_id": objectId,
+	id, text := handler.wantsText(c, c.Param("id"))
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	servings, ok := parseServings(c)
+	if !ok {
+		return
+	}
+
+	// each organization has its own copy, only the one of the recipe is
+	// ever found
+	cacheKey, org := "recipe:"+id, orgOf(c)
+	if handler.cacheTTL > 0 {
+		val, found, unlock, err := handler.cacheLookup(c.Request.Context(), cacheKey+":"+org, func() (string, error) {
+			return handler.redisClient.HGet(cacheKey, org).Result()
+		})
+		if err != nil {
+			loggerFrom(c.Request.Context()).Warn("Failed to read recipe from cache", "error", err)
+		}
+		defer unlock()
+		if found {
+			var recipe models.Recipe
+			json.Unmarshal([]byte(val), &recipe)
+			if !scaleRecipe(c, &recipe, servings) {
+				return
+			}
+			handler.setFavorite(c, &recipe)
+			handler.countView(c, id)
+			c.Header("X-Cache", "HIT")
+			if text {
+				respondRecipeText(c, recipe)
+				return
+			}
+			handler.respondRecipeWithETag(c, recipe)
+			return
+		}
+	}
+
+	if databaseUnavailable(c) {
+		return
+	}
+	cur := handler.collection.FindOne(c.Request.Context(), bson.M{
+		"_id":       objectId,
+		"orgId":     org,
+		"deletedAt": notDeleted,
 	})
 	var recipe models.Recipe
 	err := cur.Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondDBError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, recipe)
+	if handler.cacheTTL > 0 {
+		data, _ := json.Marshal(recipe)
+		handler.cacheVariant(cacheKey, org, string(data), handler.cacheTTL)
+	}
+	if !scaleRecipe(c, &recipe, servings) {
+		return
+	}
+	handler.setFavorite(c, &recipe)
+	handler.countView(c, id)
+	c.Header("X-Cache", "MISS")
+	if text {
+		respondRecipeText(c, recipe)
+		return
+	}
+	handler.respondRecipeWithETag(c, recipe)
 }
 
-// swagger:operation GET /recipes/search recipes findRecipe
-// Search recipes based on tags
+// swagger:operation GET /recipes/search recipes searchRecipes
+// Search recipes by name and ingredients
+//
+// With READ_SECONDARY set, searches read from the secondaries and may miss
+// recipes for a moment after they are written.
 // ---
 // produces:
 // - application/json
+// - application/xml
 // parameters:
-//   - name: tag
+//   - name: q
 //     in: query
-//     description: recipe tag
+//     description: search terms
 //     required: true
 //     type: string
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: limit
+//     in: query
+//     description: number of recipes per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//   - name: sort
+//     in: query
+//     description: sort order instead of relevance, prefix with - for descending
+//     required: false
+//     type: string
+//     enum: [name, -name, publishedAt, -publishedAt, avgRating, -avgRating]
+//   - name: includeScore
+//     in: query
+//     description: include the relevance score of each recipe
+//     required: false
+//     type: boolean
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
 // responses:
-//     '200':
-//         description: Successful operation
-/*func SearchRecipesHandler(c *gin.Context) {
-	tag := c.Query("tag")
-	listOfRecipes := make([]Recipe, 0)
-
-	for i := 0; i < len(recipes); i++ {
-		found := false
-		for _, t := range recipes[i].Tags {
-			if strings.EqualFold(t, tag) {
-				found = true
-			}
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Missing search terms or invalid sort
+func (handler *RecipesHandler) SearchRecipesHandler(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		respondError(c, http.StatusBadRequest, "bad_request", "q must not be empty")
+		return
+	}
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	sortValue := c.Query("sort")
+	sortDoc, err := parseSort(sortValue)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	includeScore := c.Query("includeScore") == "true"
+
+	filter, fullText, err := handler.searchFilter(c.Request.Context(), q)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	filter["deletedAt"] = notDeleted
+	inOrg(c, filter)
+
+	// $text results are ordered by relevance unless a sort is given. The
+	// regex fallback has no notion of relevance: its results come in the
+	// requested sort order, or in creation order, and carry no score.
+	// X-Search-Mode tells clients which of the two they got.
+	opts := options.Find()
+	if fullText {
+		score := bson.M{"$meta": "textScore"}
+		opts.SetProjection(bson.M{"score": score})
+		if sortValue == "" {
+			sortDoc = bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}
 		}
-		if found {
-			listOfRecipes = append(listOfRecipes, recipes[i])
+		c.Header("X-Search-Mode", "text")
+	} else {
+		c.Header("X-Search-Mode", "regex")
+	}
+	opts.SetSort(sortDoc)
+
+	list, err := findPage(c.Request.Context(), handler.listCollection, filter, opts, page, limit)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if !includeScore {
+		for i := range list.Data {
+			list.Data[i].Score = nil
 		}
 	}
+	setPaginationLinks(c, list)
+	handler.respond(c, http.StatusOK, list)
+}
 
-	c.JSON(http.StatusOK, listOfRecipes)
-}*/
+// searchFilter matches q against the name and ingredients, with $text when
+// the collection has a text index (fullText is then true) and a
+// case-insensitive regex otherwise.
+func (handler *RecipesHandler) searchFilter(ctx context.Context, q string) (filter bson.M, fullText bool, err error) {
+	hasTextIndex, err := handler.hasTextIndex(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if hasTextIndex {
+		return bson.M{"$text": bson.M{"$search": q}}, true, nil
+	}
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+	return bson.M{"$or": bson.A{
+		bson.M{"name": pattern},
+		bson.M{"ingredients": pattern},
+	}}, false, nil
+}
+
+// hasTextIndex reports whether the recipes collection has a text index, in
+// which case searches can use $text instead of a collection scan.
+func (handler *RecipesHandler) hasTextIndex(ctx context.Context) (bool, error) {
+	cur, err := handler.collection.Indexes().List(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var index struct {
+			Key bson.M `bson:"key"`
+		}
+		if err := cur.Decode(&index); err != nil {
+			return false, err
+		}
+		if index.Key["_fts"] == "text" {
+			return true, nil
+		}
+	}
+	return false, cur.Err()
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// recipesRouter routes the recipe handlers the tests use, with user as the
+// caller.
+func recipesRouter(handler *RecipesHandler, user AuthUser) *gin.Engine {
+	router := gin.New()
+	router.Use(asUser(user))
+	router.POST("/recipes", handler.NewRecipeHandler)
+	router.GET("/recipes", handler.ListRecipesHandler)
+	router.GET("/recipes/:id", handler.GetOneRecipeHandler)
+	router.PUT("/recipes/:id", handler.UpdateRecipeHandler)
+	router.PATCH("/recipes/:id", handler.PatchRecipeHandler)
+	router.DELETE("/recipes/:id", handler.DeleteRecipeHandler)
+	return router
+}
+
+// createRecipe creates a recipe through the API and returns it as stored.
+func createRecipe(t *testing.T, router http.Handler, body string) models.Recipe {
+	t.Helper()
+	w := serve(router, http.MethodPost, "/recipes", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	var recipe models.Recipe
+	if err := json.Unmarshal(w.Body.Bytes(), &recipe); err != nil {
+		t.Fatal(err)
+	}
+	return recipe
+}
+
+func TestListRecipesReflectsWrites(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	router := recipesRouter(testRecipesHandler(t, db, redisClient), AuthUser{Username: "cook"})
+
+	list := func(wantCache string) []models.Recipe {
+		t.Helper()
+		w := serve(router, http.MethodGet, "/recipes", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("list: got %d %s", w.Code, w.Body)
+		}
+		if got := w.Header().Get("X-Cache"); got != wantCache {
+			t.Errorf("list: X-Cache %q, want %q", got, wantCache)
+		}
+		var page models.RecipeList
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Data
+	}
+
+	if recipes := list("MISS"); len(recipes) != 0 {
+		t.Fatalf("empty database listed %d recipes", len(recipes))
+	}
+	// the empty page is cached now, creating a recipe must drop it
+	list("HIT")
+	recipe := createRecipe(t, router, `{"name": "Pancakes", "ingredients": ["flour", "milk"], "instructions": ["mix", "fry"]}`)
+
+	recipes := list("MISS")
+	if len(recipes) != 1 || recipes[0].ID != recipe.ID {
+		t.Fatalf("after create: listed %+v, want only %s", recipes, recipe.ID.Hex())
+	}
+	list("HIT")
+
+	if w := serve(router, http.MethodDelete, "/recipes/"+recipe.ID.Hex(), ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: got %d %s", w.Code, w.Body)
+	}
+	if recipes := list("MISS"); len(recipes) != 0 {
+		t.Fatalf("after delete: listed %+v, want none", recipes)
+	}
+}
+
+func TestMissingRecipeIsNotFound(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	router := recipesRouter(testRecipesHandler(t, db, redisClient), AuthUser{Username: "cook"})
+	createRecipe(t, router, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`)
+	path := "/recipes/" + primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		method string
+		body   string
+	}{
+		{http.MethodGet, ""},
+		{http.MethodPut, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"], "version": 1}`},
+		{http.MethodDelete, ""},
+	}
+	for _, tt := range tests {
+		if w := serve(router, tt.method, path, tt.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d %s, want 404", tt.method, w.Code, w.Body)
+		}
+	}
+}
+
+func TestUpdateRecipeRejectsStaleVersions(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	router := recipesRouter(testRecipesHandler(t, db, redisClient), AuthUser{Username: "cook"})
+	recipe := createRecipe(t, router, `{"name": "Soup", "ingredients": ["water", "salt"], "instructions": ["boil"]}`)
+	path := "/recipes/" + recipe.ID.Hex()
+	etag := func() string {
+		t.Helper()
+		w := serve(router, http.MethodGet, path, "")
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+			t.Fatalf("get: got %d with ETag %q", w.Code, w.Header().Get("ETag"))
+		}
+		return w.Header().Get("ETag")
+	}
+
+	// two clients read version 1 and save it back at the same time
+	stale := etag()
+	statuses := make([]int, 2)
+	var wg sync.WaitGroup
+	for i, name := range []string{"Tomato soup", "Onion soup"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = serve(router, http.MethodPut, path, `{"name": "`+name+`", "ingredients": ["water"], "instructions": ["boil"]}`, "If-Match", stale).Code
+		}()
+	}
+	wg.Wait()
+	sort.Ints(statuses)
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusConflict {
+		t.Fatalf("concurrent updates: got %v, want one 200 and one 409", statuses)
+	}
+
+	current := etag()
+	tests := []struct {
+		name   string
+		header []string
+		body   string
+		status int
+	}{
+		{"stale ETag", []string{"If-Match", stale}, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`, http.StatusConflict},
+		{"stale bare version", []string{"If-Match", `"1"`}, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`, http.StatusConflict},
+		{"stale version field", nil, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"], "version": 1}`, http.StatusConflict},
+		{"no version", nil, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`, http.StatusPreconditionRequired},
+		{"current ETag", []string{"If-Match", current}, `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		if w := serve(router, http.MethodPut, path, test.body, test.header...); w.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, w.Code, w.Body, test.status)
+		}
+	}
+
+	w := serve(router, http.MethodGet, path, "")
+	var stored models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &stored)
+	if stored.Version == nil || *stored.Version != 3 || stored.Name != "Soup" {
+		t.Errorf("stored %+v, want version 3 named Soup", stored)
+	}
+}
+
+func TestPatchRecipeKeepsOmittedFields(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	router := recipesRouter(testRecipesHandler(t, db, redisClient), AuthUser{Username: "cook"})
+	recipe := createRecipe(t, router, `{"name": "Crepes", "tags": ["sweet"], "cuisine": "french", "ingredients": ["flour", "eggs", "milk"], "instructions": ["mix", "fry"]}`)
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := serve(router, http.MethodPatch, path, `{"name": "Thin crepes", "cuisine": null}`, "If-Match", `"1"`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch: got %d %s", w.Code, w.Body)
+	}
+
+	w = serve(router, http.MethodGet, path, "")
+	var stored models.Recipe
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "Thin crepes" {
+		t.Errorf("name %q, want Thin crepes", stored.Name)
+	}
+	if len(stored.Ingredients) != 3 || stored.Ingredients[0] != "flour" || len(stored.Instructions) != 2 {
+		t.Errorf("ingredients %q and instructions %q, want them untouched", stored.Ingredients, stored.Instructions)
+	}
+	if len(stored.Tags) != 1 || stored.Tags[0] != "sweet" {
+		t.Errorf("tags %q, want them untouched", stored.Tags)
+	}
+	if stored.Cuisine != "" {
+		t.Errorf("cuisine %q, want it cleared by null", stored.Cuisine)
+	}
+	if stored.Version == nil || *stored.Version != 2 {
+		t.Errorf("version %v, want 2", stored.Version)
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	const maxLimit = 50
+	tests := []struct {
+		query   string
+		limit   int64
+		clamped bool
+		invalid bool
+	}{
+		{"", defaultLimit, false, false},
+		{"limit=1", 1, false, false},
+		{"limit=49", 49, false, false},
+		{"limit=50", 50, false, false},
+		{"limit=51", 50, true, false},
+		{"limit=100000", 50, true, false},
+		{"limit=0", 0, false, true},
+		{"limit=-1", 0, false, true},
+		{"limit=ten", 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/recipes?"+tt.query, nil)
+			_, limit, err := parsePagination(c, maxLimit)
+			if (err != nil) != tt.invalid {
+				t.Fatalf("got error %v, want invalid %v", err, tt.invalid)
+			}
+			if limit != tt.limit {
+				t.Errorf("got limit %d, want %d", limit, tt.limit)
+			}
+			if clamped := w.Header().Get("X-Limit-Clamped") == "true"; clamped != tt.clamped {
+				t.Errorf("got X-Limit-Clamped %v, want %v", clamped, tt.clamped)
+			}
+		})
+	}
+
+	// a maximum below the default lowers the default too, without the header
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/recipes", nil)
+	if _, limit, _ := parsePagination(c, 10); limit != 10 || w.Header().Get("X-Limit-Clamped") != "" {
+		t.Errorf("got limit %d and header %q, want 10 and none", limit, w.Header().Get("X-Limit-Clamped"))
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"golang.org/x/net/context"
+)
+
+type HealthHandler struct {
+	client      *mongo.Client
+	ctx         context.Context
+	redisClient *redis.Client
+}
+
+func NewHealthHandler(ctx context.Context, client *mongo.Client, redisClient *redis.Client) *HealthHandler {
+	return &HealthHandler{
+		client:      client,
+		ctx:         ctx,
+		redisClient: redisClient,
+	}
+}
+
+// swagger:operation GET /health health health
+// Liveness probe
+//
+// LivenessHandler reports that the process is up, without touching any dependency.
+// ---
+// responses:
+//
+//	'200':
+//	    description: The process is up
+func (handler *HealthHandler) LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// swagger:operation GET /ready health ready
+// Readiness probe checking MongoDB and Redis
+//
+// ReadinessHandler answers 503 with the name of the failing dependency when
+// one of them is unreachable.
+// ---
+// responses:
+//
+//	'200':
+//	    description: All dependencies are reachable
+//	'503':
+//	    description: A dependency is down
+func (handler *HealthHandler) ReadinessHandler(c *gin.Context) {
+	if err := handler.pingMongo(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "dependency": "mongodb", "error": APIError{
+			Code: "dependency_unavailable", Message: err.Error(), RequestID: GetRequestID(c),
+		}})
+		return
+	}
+	if err := handler.pingRedis(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "dependency": "redis", "error": APIError{
+			Code: "dependency_unavailable", Message: err.Error(), RequestID: GetRequestID(c),
+		}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// WatchDependencies pings MongoDB and Redis every interval to keep the
+// dependency_up gauges current, until ctx is cancelled.
+func (handler *HealthHandler) WatchDependencies(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		handler.pingMongo()
+		handler.pingRedis()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (handler *HealthHandler) pingMongo() error {
+	err := handler.client.Ping(handler.ctx, readpref.Primary())
+	setDependencyUp("mongodb", err == nil)
+	return err
+}
+
+func (handler *HealthHandler) pingRedis() error {
+	err := handler.redisClient.Ping().Err()
+	setDependencyUp("redis", err == nil)
+	return err
+}
+
+// dependencyStates remembers whether each dependency was up at its last
+// check, so that only the changes get logged.
+var dependencyStates sync.Map
+
+func setDependencyUp(dependency string, up bool) {
+	if previous, loaded := dependencyStates.Swap(dependency, up); loaded && previous.(bool) != up {
+		if up {
+			slog.Info("Dependency is back up", "dependency", dependency)
+		} else {
+			slog.Warn("Dependency is down", "dependency", dependency)
+		}
+	}
+	value := 0.0
+	if up {
+		value = 1
+	}
+	dependencyUp.WithLabelValues(dependency).Set(value)
+}
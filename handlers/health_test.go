@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// ready answers GET /ready and returns the status and the failing dependency.
+func ready(t *testing.T, client *mongo.Client, redisClient *redis.Client) (int, string) {
+	t.Helper()
+	router := gin.New()
+	router.GET("/ready", NewHealthHandler(context.Background(), client, redisClient).ReadinessHandler)
+	w := serve(router, http.MethodGet, "/ready", "")
+	var body struct {
+		Dependency string `json:"dependency"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, body.Dependency
+}
+
+func TestReadyWithRedisDown(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	if status, _ := ready(t, db.Client(), redisClient); status != http.StatusOK {
+		t.Fatalf("both up: got %d, want 200", status)
+	}
+
+	down := redis.NewClient(&redis.Options{Addr: closedAddr(t), MaxRetries: 0, DialTimeout: time.Second})
+	defer down.Close()
+	if status, dependency := ready(t, db.Client(), down); status != http.StatusServiceUnavailable || dependency != "redis" {
+		t.Errorf("redis down: got %d %q, want 503 redis", status, dependency)
+	}
+}
+
+func TestReadyWithMongoDown(t *testing.T) {
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://"+closedAddr(t)).SetServerSelectionTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	redisClient, _ := testRedis(t)
+
+	if status, dependency := ready(t, client, redisClient); status != http.StatusServiceUnavailable || dependency != "mongodb" {
+		t.Errorf("mongodb down: got %d %q, want 503 mongodb", status, dependency)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// idempotencyTTL is how long a response is kept for retries.
+	idempotencyTTL = 24 * time.Hour
+	// pendingMargin is how long a reserved key outlives the deadline of its
+	// request, so a request that dies without answering frees it soon after.
+	pendingMargin = 30 * time.Second
+	// pendingTTL reserves the key of a request without a deadline.
+	pendingTTL = time.Minute
+)
+
+// idempotency tracks a request sent with an Idempotency-Key header. The key
+// is reserved in Redis while the request runs and then holds the response,
+// which is replayed to retries carrying the same key and payload.
+type idempotency struct {
+	redisClient *redis.Client
+	key         string
+	hash        string
+	xml         bool
+	logger      *slog.Logger
+}
+
+type idempotentResponse struct {
+	Hash        string `json:"hash"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// startIdempotent reserves the Idempotency-Key of the request, if any. It
+// returns false when it already answered: with the stored response for a
+// retry, in the format the first request got, 422 when the key was used for
+// another payload, 409 while the first request is still running and 413 when
+// the body is larger than allowed.
+func (handler *RecipesHandler) startIdempotent(c *gin.Context) (*idempotency, bool) {
+	header := c.GetHeader("Idempotency-Key")
+	if header == "" {
+		return nil, true
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondBodyTooLarge(c, maxBytesErr.Limit)
+			return nil, false
+		}
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	// keys are scoped to the organization and user so clients can't collide
+	// with each other, or replay a response of another tenant
+	user, _ := CurrentUser(c)
+	idem := &idempotency{
+		redisClient: handler.redisClient,
+		key:         "idempotency:" + orgOf(c) + ":" + user.Username + ":" + header,
+		hash:        hex.EncodeToString(sum[:]),
+		xml:         handler.wantsXML(c),
+		logger:      loggerFrom(c.Request.Context()),
+	}
+
+	// the reservation only lasts as long as the request may run, complete
+	// keeps the response for idempotencyTTL
+	ttl := pendingTTL
+	if deadline, ok := c.Request.Context().Deadline(); ok {
+		ttl = time.Until(deadline) + pendingMargin
+	}
+	pending, _ := json.Marshal(idempotentResponse{Hash: idem.hash})
+	reserved, err := handler.redisClient.SetNX(idem.key, pending, ttl).Result()
+	if err != nil {
+		respondDBError(c, err)
+		return nil, false
+	}
+	if reserved {
+		return idem, true
+	}
+
+	val, err := handler.redisClient.Get(idem.key).Bytes()
+	if err != nil {
+		respondDBError(c, err)
+		return nil, false
+	}
+	var previous idempotentResponse
+	json.Unmarshal(val, &previous)
+	switch {
+	case previous.Hash != idem.hash:
+		respondError(c, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used with a different payload")
+	case previous.Status == 0:
+		respondError(c, http.StatusConflict, "idempotency_key_in_use", "A request with this Idempotency-Key is still in progress")
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(previous.Status, previous.ContentType, previous.Body)
+	}
+	return nil, false
+}
+
+// complete stores the response for retries, encoded the way respond sends it
+// to the first request. It does nothing without a key.
+func (idem *idempotency) complete(status int, body interface{}) {
+	if idem == nil {
+		return
+	}
+	contentType, marshal := "application/json; charset=utf-8", json.Marshal
+	if idem.xml {
+		contentType, marshal = "application/xml; charset=utf-8", xml.Marshal
+	}
+	data, err := marshal(body)
+	if err == nil {
+		data, err = json.Marshal(idempotentResponse{Hash: idem.hash, Status: status, ContentType: contentType, Body: data})
+	}
+	if err == nil {
+		err = idem.redisClient.Set(idem.key, data, idempotencyTTL).Err()
+	}
+	if err != nil {
+		idem.logger.Warn("Failed to store idempotent response", "error", err)
+	}
+}
+
+// abandon releases the key after a failure so the request can be retried.
+func (idem *idempotency) abandon() {
+	if idem == nil {
+		return
+	}
+	if err := idem.redisClient.Del(idem.key).Err(); err != nil {
+		idem.logger.Warn("Failed to release Idempotency-Key", "error", err)
+	}
+}
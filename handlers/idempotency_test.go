@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotentRetryKeepsFormat(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	router := recipesRouter(testRecipesHandler(t, db, redisClient), AuthUser{Username: "cook"})
+	recipe := `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`
+
+	for _, accept := range []string{"application/json", "application/xml"} {
+		key := "create-" + accept
+		first := serve(router, http.MethodPost, "/recipes", recipe, "Idempotency-Key", key, "Accept", accept)
+		if first.Code != http.StatusOK || !strings.HasPrefix(first.Header().Get("Content-Type"), accept) {
+			t.Fatalf("%s: got %d %q %s, want 200 in that format", accept, first.Code, first.Header().Get("Content-Type"), first.Body)
+		}
+		retry := serve(router, http.MethodPost, "/recipes", recipe, "Idempotency-Key", key, "Accept", accept)
+		if retry.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatalf("%s: retry wasn't replayed: %d %s", accept, retry.Code, retry.Body)
+		}
+		if retry.Code != first.Code || retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") ||
+			!bytes.Equal(retry.Body.Bytes(), first.Body.Bytes()) {
+			t.Errorf("%s: replayed %d %q %s, want %d %q %s", accept,
+				retry.Code, retry.Header().Get("Content-Type"), retry.Body,
+				first.Code, first.Header().Get("Content-Type"), first.Body)
+		}
+		// the recipe exists once, the duplicate check would refuse another
+		recipe = strings.Replace(recipe, "Soup", "Stew", 1)
+	}
+}
+
+func TestIdempotentBodyTooLarge(t *testing.T) {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 16)
+	})
+	router.POST("/recipes", (&RecipesHandler{}).NewRecipeHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/recipes", strings.NewReader(`{"name": "A soup far too long"}`))
+	req.Header.Set("Idempotency-Key", "large")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":"payload_too_large"`) {
+		t.Errorf("got %d %s, want 413 payload_too_large", w.Code, w.Body)
+	}
+}
+
+func TestIdempotencyKeyLifetime(t *testing.T) {
+	redisClient, redisServer := testRedis(t)
+	handler := &RecipesHandler{redisClient: redisClient}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/recipes", strings.NewReader(`{"name": "Soup"}`)).WithContext(ctx)
+	c.Request.Header.Set("Idempotency-Key", "create")
+	setCurrentUser(c, AuthUser{Username: "cook"})
+
+	idem, ok := handler.startIdempotent(c)
+	if !ok || idem == nil {
+		t.Fatal("the key wasn't reserved")
+	}
+	if ttl := redisServer.TTL(idem.key); ttl <= 0 || ttl > 10*time.Second+pendingMargin {
+		t.Errorf("the reservation lives %s, want the request deadline plus %s", ttl, pendingMargin)
+	}
+	idem.complete(http.StatusOK, gin.H{"name": "Soup"})
+	if ttl := redisServer.TTL(idem.key); ttl != idempotencyTTL {
+		t.Errorf("the response lives %s, want %s", ttl, idempotencyTTL)
+	}
+}
+
+func TestIdempotencyKeyIsPerOrganization(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	handler := testRecipesHandler(t, db, redisClient)
+	recipe := `{"name": "Soup", "ingredients": ["water"], "instructions": ["boil"]}`
+
+	// the same API key user, in two organizations
+	for _, org := range []string{"north", "south"} {
+		router := recipesRouter(handler, AuthUser{Username: "service:importer", OrgID: org})
+		w := serve(router, http.MethodPost, "/recipes", recipe, "Idempotency-Key", "import-1")
+		if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("%s: got %d replayed %q %s, want a recipe of its own", org, w.Code, w.Header().Get("Idempotent-Replayed"), w.Body)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+const maxImageSize = 5 << 20
+
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// ImagesHandler stores recipe images on disk under dir. It relies on the
+// recipes handler for ownership checks and cache invalidation. The URLs of
+// the images start with basePath.
+type ImagesHandler struct {
+	recipes  *RecipesHandler
+	dir      string
+	basePath string
+}
+
+func NewImagesHandler(recipes *RecipesHandler, dir, basePath string) *ImagesHandler {
+	return &ImagesHandler{
+		recipes:  recipes,
+		dir:      dir,
+		basePath: basePath,
+	}
+}
+
+// swagger:operation POST /recipes/{id}/image recipes uploadRecipeImage
+// Upload the image of a recipe as a multipart "image" field (JPEG or PNG, up to 5MB)
+// ---
+// consumes:
+// - multipart/form-data
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: image
+//     in: formData
+//     description: JPEG or PNG image
+//     required: true
+//     type: file
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Missing, oversized or non-image upload
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: Recipe not found
+func (handler *ImagesHandler) UploadImageHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	if !handler.recipes.authorizeOwner(c, objectId) {
+		return
+	}
+
+	header, contentType, extension, ok := readImageUpload(c)
+	if !ok {
+		return
+	}
+	fileName := id + extension
+	if !handler.saveImage(c, header, fileName) {
+		return
+	}
+
+	image := models.Image{
+		File:        fileName,
+		ContentType: contentType,
+		URL:         fmt.Sprintf("%s/recipes/%s/image", handler.basePath, id),
+	}
+	var previous models.Recipe
+	err := handler.recipes.collection.FindOneAndUpdate(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	}), bson.M{"$set": bson.M{"image": image, "updatedAt": time.Now()}}).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	// a PNG replacing a JPEG (or the reverse) leaves the old file behind
+	if previous.Image != nil && previous.Image.File != fileName {
+		handler.removeImage(c, previous.Image.File)
+	}
+
+	handler.recipes.invalidateCache(id)
+
+	c.JSON(http.StatusOK, image)
+}
+
+// readImageUpload reads the multipart "image" field, a JPEG or PNG of at
+// most maxImageSize. It answers the request itself when the upload is
+// missing or not an image.
+func readImageUpload(c *gin.Context) (header *multipart.FileHeader, contentType, extension string, ok bool) {
+	// leave some room for the multipart envelope around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageSize+1<<20)
+	header, err := c.FormFile("image")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", "An image of at most 5MB is required in the image field")
+		return nil, "", "", false
+	}
+	if header.Size > maxImageSize {
+		respondError(c, http.StatusBadRequest, "bad_request", "Image must not exceed 5MB")
+		return nil, "", "", false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return nil, "", "", false
+	}
+	defer file.Close()
+
+	// trust the content rather than the client supplied content type
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	contentType = http.DetectContentType(sniff[:n])
+	extension, ok = imageExtensions[contentType]
+	if !ok {
+		respondError(c, http.StatusBadRequest, "unsupported_image_type", "Only JPEG and PNG images are accepted")
+		return nil, "", "", false
+	}
+	return header, contentType, extension, true
+}
+
+// saveImage stores the upload as fileName in the images directory.
+func (handler *ImagesHandler) saveImage(c *gin.Context, header *multipart.FileHeader, fileName string) bool {
+	if err := os.MkdirAll(handler.dir, 0o755); err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Error while storing the image")
+		return false
+	}
+	if err := c.SaveUploadedFile(header, filepath.Join(handler.dir, fileName)); err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Error while storing the image")
+		return false
+	}
+	return true
+}
+
+// removeImage deletes an image file that is no longer referenced.
+func (handler *ImagesHandler) removeImage(c *gin.Context, fileName string) {
+	if err := os.Remove(filepath.Join(handler.dir, fileName)); err != nil && !os.IsNotExist(err) {
+		loggerFrom(c.Request.Context()).Warn("Failed to remove image", "file", fileName, "error", err)
+	}
+}
+
+// swagger:operation GET /recipes/{id}/image recipes getRecipeImage
+// Download the image of a recipe
+// ---
+// produces:
+// - image/jpeg
+// - image/png
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid recipe ID
+//	'404':
+//	    description: Recipe or image not found
+func (handler *ImagesHandler) GetImageHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+
+	var recipe models.Recipe
+	err := handler.recipes.collection.FindOne(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	})).Decode(&recipe)
+	if err == mongo.ErrNoDocuments || (err == nil && recipe.Image == nil) {
+		respondError(c, http.StatusNotFound, "not_found", "Image not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", recipe.Image.ContentType)
+	c.File(filepath.Join(handler.dir, recipe.Image.File))
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// setPaginationLinks adds an RFC 5988 Link header pointing at the first,
+// previous, next and last pages of list, keeping the other query parameters
+// of the request. An empty result still has a first and last page.
+func setPaginationLinks(c *gin.Context, list models.RecipeList) {
+	setPageLinks(c, list.Page, list.TotalPages)
+}
+
+// setPageLinks writes the Link header for any paginated list.
+func setPageLinks(c *gin.Context, page, totalPages int64) {
+	lastPage := totalPages
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	links := []string{pageLink(c, 1, "first")}
+	if page > 1 {
+		prev := page - 1
+		if prev > lastPage {
+			prev = lastPage
+		}
+		links = append(links, pageLink(c, prev, "prev"))
+	}
+	if page < lastPage {
+		links = append(links, pageLink(c, page+1, "next"))
+	}
+	links = append(links, pageLink(c, lastPage, "last"))
+
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+func pageLink(c *gin.Context, page int64, rel string) string {
+	query := c.Request.URL.Query()
+	query.Set("page", strconv.FormatInt(page, 10))
+	return fmt.Sprintf(`<%s?%s>; rel="%s"`, c.Request.URL.Path, query.Encode(), rel)
+}
+
+// setCursorLink adds a Link header pointing at the next page, when there is
+// one, for lists read with a cursor.
+func setCursorLink(c *gin.Context, next string) {
+	if next == "" {
+		return
+	}
+	query := c.Request.URL.Query()
+	query.Set("cursor", next)
+	c.Header("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, c.Request.URL.Path, query.Encode()))
+}
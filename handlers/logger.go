@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/context"
+)
+
+type loggerKey struct{}
+
+// Logger hands every request a logger tagged with its request id, which
+// handlers get back with loggerFrom, and logs one access line per request.
+// It must run after RequestID. The username is set by the auth middlewares,
+// so it is only known once the request has been handled.
+func Logger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		if c.Request.URL.RawQuery != "" {
+			path += "?" + c.Request.URL.RawQuery
+		}
+		requestLogger := logger.With("requestId", GetRequestID(c))
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), loggerKey{}, requestLogger))
+
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		user, _ := CurrentUser(c)
+		requestLogger.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", path,
+			"status", c.Writer.Status(),
+			"latencyMs", float64(time.Since(start).Microseconds())/1000,
+			"clientIp", c.ClientIP(),
+			"username", user.Username,
+		)
+	}
+}
+
+// loggerFrom returns the logger of the request ctx belongs to, or the
+// default logger outside of a request.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
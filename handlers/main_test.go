@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testRedis starts an in-memory Redis for the test.
+func testRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+// testDatabase returns an empty database on the MongoDB of MONGO_TEST_URI,
+// dropped once the test is done. Tests needing MongoDB are skipped when the
+// variable is unset.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database("recipes_test_" + xid.New().String())
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return db
+}
+
+// testRecipesHandler returns a RecipesHandler caching in Redis for a minute,
+// with XML enabled and the default limits.
+func testRecipesHandler(t *testing.T, db *mongo.Database, redisClient *redis.Client) *RecipesHandler {
+	t.Helper()
+	return NewRecipesHandler(context.Background(), db.Collection("recipes"), redisClient, time.Minute, nil, true, 0.5, 100, false)
+}
+
+// asUser plays the part of the auth middlewares, making user the caller.
+func asUser(user AuthUser) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setCurrentUser(c, user)
+		c.Next()
+	}
+}
+
+// serve sends a request to router and returns the recorded response. header
+// holds pairs of header names and values.
+func serve(router http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = bytes.NewBufferString(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// countRelated looks up the documents of collection referring to the recipe
+// by recipeId, reduced by group to a single document stored in as.
+func countRelated(collection, as string, group bson.M) bson.M {
+	group["_id"] = nil
+	return bson.M{"$lookup": bson.M{
+		"from": collection,
+		"let":  bson.M{"recipeId": "$_id"},
+		"pipeline": bson.A{
+			bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$recipeId", "$$recipeId"}}}},
+			bson.M{"$group": group},
+		},
+		"as": as,
+	}}
+}
+
+// swagger:operation GET /me/recipes recipes listMyRecipes
+// Returns the recipes of the signed in user with their stats, newest first
+//
+// The stats hold the views, the number of users who favorited the recipe and
+// its average rating. Views are counted in Redis and written to the recipe
+// every VIEWS_FLUSH_INTERVAL, so the latest ones may be missing.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: limit
+//     in: query
+//     description: number of recipes per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid query parameters
+func (handler *RecipesHandler) MyRecipesHandler(c *gin.Context) {
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	// the stats are only computed for the recipes of the page
+	user, _ := CurrentUser(c)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: inOrg(c, bson.M{"owner": user.Username, "deletedAt": notDeleted})}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "count"}},
+			"data": bson.A{
+				bson.M{"$skip": (page - 1) * limit},
+				bson.M{"$limit": limit},
+				countRelated(handler.favorites().Name(), "favorites", bson.M{"count": bson.M{"$sum": 1}}),
+				countRelated(handler.ratings().Name(), "ratings", bson.M{"avg": bson.M{"$avg": "$score"}, "count": bson.M{"$sum": 1}}),
+				bson.M{"$set": bson.M{"stats": bson.M{
+					"views":       bson.M{"$ifNull": bson.A{"$views", 0}},
+					"favorites":   bson.M{"$ifNull": bson.A{bson.M{"$first": "$favorites.count"}, 0}},
+					"avgRating":   bson.M{"$round": bson.A{bson.M{"$ifNull": bson.A{bson.M{"$first": "$ratings.avg"}, 0}}, 2}},
+					"ratingCount": bson.M{"$ifNull": bson.A{bson.M{"$first": "$ratings.count"}, 0}},
+				}}},
+				bson.M{"$unset": bson.A{"favorites", "ratings"}},
+			},
+		}}},
+	}
+	cur, err := handler.collection.Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(c.Request.Context())
+
+	var result struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Data []models.OwnedRecipe `bson:"data"`
+	}
+	if cur.Next(c.Request.Context()) {
+		if err := cur.Decode(&result); err != nil {
+			respondDBError(c, err)
+			return
+		}
+	}
+
+	list := models.OwnedRecipeList{Data: result.Data, Page: page, Limit: limit}
+	if list.Data == nil {
+		list.Data = []models.OwnedRecipe{}
+	}
+	if len(result.Total) > 0 {
+		list.Total = result.Total[0].Count
+	}
+	list.TotalPages = (list.Total + limit - 1) / limit
+	setPageLinks(c, list.Page, list.TotalPages)
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// formats lists the representations the recipe handlers offer, followed by
+// extra. JSON comes first so clients without a preference keep getting it.
+func (handler *RecipesHandler) formats(extra ...string) []string {
+	offered := []string{binding.MIMEJSON}
+	if handler.xmlOutput {
+		offered = append(offered, binding.MIMEXML, binding.MIMEXML2)
+	}
+	return append(offered, extra...)
+}
+
+// wantsXML reports whether the Accept header prefers XML over JSON.
+func (handler *RecipesHandler) wantsXML(c *gin.Context) bool {
+	if !handler.xmlOutput {
+		return false
+	}
+	varyOnAccept(c)
+	switch c.NegotiateFormat(handler.formats()...) {
+	case binding.MIMEXML, binding.MIMEXML2:
+		return true
+	}
+	return false
+}
+
+// respond writes obj as XML to clients asking for it and as JSON to the
+// others. Errors are always written as JSON by respondError.
+func (handler *RecipesHandler) respond(c *gin.Context, status int, obj interface{}) {
+	if handler.wantsXML(c) {
+		c.XML(status, obj)
+		return
+	}
+	c.JSON(status, obj)
+}
+
+// varyOnAccept tells caches the response depends on the Accept header. It
+// adds to the Vary values of the other middlewares, such as Origin and
+// Accept-Encoding, instead of replacing them, and only once.
+func varyOnAccept(c *gin.Context) {
+	header := c.Writer.Header()
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if http.CanonicalHeaderKey(strings.TrimSpace(name)) == "Accept" {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Accept")
+}
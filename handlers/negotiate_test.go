@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+func TestGetOneRecipeNegotiatesFormat(t *testing.T) {
+	db := testDatabase(t)
+	redisClient, _ := testRedis(t)
+	handler := testRecipesHandler(t, db, redisClient)
+
+	recipe := models.Recipe{ID: primitive.NewObjectID(), Name: "Pancakes", Ingredients: []string{"flour", "milk"}, OrgID: DefaultOrg}
+	if _, err := db.Collection("recipes").InsertOne(context.Background(), recipe); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(CORS([]string{"*"}, nil, nil, 0), asUser(AuthUser{Username: "cook"}))
+	router.GET("/recipes/:id", handler.GetOneRecipeHandler)
+
+	// the second request of each format is served from the cache
+	for _, cached := range []string{"MISS", "HIT"} {
+		w := serve(router, http.MethodGet, "/recipes/"+recipe.ID.Hex(), "", "Accept", "application/json", "Origin", "https://example.com")
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("JSON %s: got %d %q", cached, w.Code, w.Header().Get("Content-Type"))
+		}
+		var fromJSON models.Recipe
+		if err := json.Unmarshal(w.Body.Bytes(), &fromJSON); err != nil || fromJSON.Name != recipe.Name {
+			t.Fatalf("JSON %s: got %s (%v)", cached, w.Body, err)
+		}
+		checkVary(t, w.Header(), "Origin", "Accept")
+
+		w = serve(router, http.MethodGet, "/recipes/"+recipe.ID.Hex(), "", "Accept", "application/xml", "Origin", "https://example.com")
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/xml") {
+			t.Fatalf("XML %s: got %d %q", cached, w.Code, w.Header().Get("Content-Type"))
+		}
+		var fromXML models.Recipe
+		if err := xml.Unmarshal(w.Body.Bytes(), &fromXML); err != nil || fromXML.Name != recipe.Name || len(fromXML.Ingredients) != 2 {
+			t.Fatalf("XML %s: got %s (%v)", cached, w.Body, err)
+		}
+		checkVary(t, w.Header(), "Origin", "Accept")
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// massUnits are the weights recipes use, in grams.
+var massUnits = map[string]float64{
+	"mg": 0.001, "g": 1, "gram": 1, "kg": 1000, "kilogram": 1000,
+	"oz": 28.35, "ounce": 28.35, "lb": 453.6, "lbs": 453.6, "pound": 453.6,
+}
+
+// volumeUnits are the volumes recipes use, in milliliters.
+var volumeUnits = map[string]float64{
+	"ml": 1, "milliliter": 1, "millilitre": 1, "l": 1000, "liter": 1000, "litre": 1000,
+	"tsp": 4.93, "teaspoon": 4.93, "tbsp": 14.79, "tablespoon": 14.79, "cup": 240,
+}
+
+// nameWord matches the words of an ingredient name, leaving out numbers and
+// punctuation.
+var nameWord = regexp.MustCompile(`[a-z]+(?:-[a-z]+)*`)
+
+// parsedIngredient is a free text ingredient such as "2 tbsp olive oil" split
+// into its quantity, its unit if it has one and the words of its name.
+type parsedIngredient struct {
+	text     string
+	quantity float64
+	unit     string
+	words    []string
+}
+
+// parseIngredient splits an ingredient like scaleIngredient reads it. What
+// follows a comma only describes the preparation, as in "1 lemon, juiced",
+// and a leading parenthesis the size, as in "4 (6-ounce) chicken breasts".
+func parseIngredient(text string) parsedIngredient {
+	ingredient := parsedIngredient{text: strings.TrimSpace(text)}
+	rest, _, _ := strings.Cut(strings.ToLower(ingredient.text), ",")
+	if match := leadingQuantity.FindString(rest); match != "" {
+		ingredient.quantity, _ = parseQuantity(match)
+		rest = strings.TrimSpace(rest[len(match):])
+	}
+	if strings.HasPrefix(rest, "(") {
+		if end := strings.Index(rest, ")"); end >= 0 {
+			rest = rest[end+1:]
+		}
+	}
+	ingredient.words = nameWord.FindAllString(rest, -1)
+	if len(ingredient.words) > 1 {
+		if unit, ok := singularUnit(ingredient.words[0]); ok {
+			ingredient.unit = unit
+			ingredient.words = ingredient.words[1:]
+		}
+	}
+	return ingredient
+}
+
+func singularUnit(word string) (string, bool) {
+	for _, unit := range []string{word, strings.TrimSuffix(word, "s")} {
+		if _, ok := massUnits[unit]; ok {
+			return unit, true
+		}
+		if _, ok := volumeUnits[unit]; ok {
+			return unit, true
+		}
+	}
+	return "", false
+}
+
+// candidateNames lists the names the ingredient could be listed under in the
+// nutrition table, longest first: every run of consecutive words, with the
+// last one also in the singular.
+func (ingredient parsedIngredient) candidateNames() []string {
+	var names []string
+	for length := len(ingredient.words); length > 0; length-- {
+		for start := 0; start+length <= len(ingredient.words); start++ {
+			name := strings.Join(ingredient.words[start:start+length], " ")
+			names = append(names, name)
+			if trimmed := strings.TrimSuffix(name, "es"); trimmed != name {
+				names = append(names, trimmed)
+			}
+			if trimmed := strings.TrimSuffix(name, "s"); trimmed != name {
+				names = append(names, trimmed)
+			}
+		}
+	}
+	return names
+}
+
+// grams weighs the ingredient using entry to convert volumes and pieces. It
+// returns false when the ingredient has no quantity that can be weighed.
+func (ingredient parsedIngredient) grams(entry models.NutritionEntry) (float64, bool) {
+	if ingredient.quantity == 0 {
+		return 0, false
+	}
+	if grams, ok := massUnits[ingredient.unit]; ok {
+		return ingredient.quantity * grams, true
+	}
+	if milliliters, ok := volumeUnits[ingredient.unit]; ok {
+		density := entry.Density
+		if density == 0 {
+			density = 1
+		}
+		return ingredient.quantity * milliliters * density, true
+	}
+	if entry.PieceWeight > 0 {
+		return ingredient.quantity * entry.PieceWeight, true
+	}
+	return 0, false
+}
+
+func (handler *RecipesHandler) nutritionTable() *mongo.Collection {
+	return handler.collection.Database().Collection("nutrition")
+}
+
+// computeNutrition looks up every ingredient of recipe in the nutrition table
+// and adds up their facts.
+func (handler *RecipesHandler) computeNutrition(ctx context.Context, recipe models.Recipe) (models.Nutrition, error) {
+	ingredients := make([]parsedIngredient, len(recipe.Ingredients))
+	names := make([]string, 0)
+	for i, text := range recipe.Ingredients {
+		ingredients[i] = parseIngredient(text)
+		names = append(names, ingredients[i].candidateNames()...)
+	}
+
+	entries := make(map[string]models.NutritionEntry)
+	if len(names) > 0 {
+		cur, err := handler.nutritionTable().Find(ctx, bson.M{"_id": bson.M{"$in": names}})
+		if err != nil {
+			return models.Nutrition{}, err
+		}
+		var found []models.NutritionEntry
+		if err := cur.All(ctx, &found); err != nil {
+			return models.Nutrition{}, err
+		}
+		for _, entry := range found {
+			entries[entry.Name] = entry
+		}
+	}
+
+	nutrition := models.Nutrition{Unknown: make([]string, 0)}
+	for _, ingredient := range ingredients {
+		counted := false
+		for _, name := range ingredient.candidateNames() {
+			entry, ok := entries[name]
+			if !ok {
+				continue
+			}
+			if grams, ok := ingredient.grams(entry); ok {
+				factor := grams / 100
+				nutrition.Total.Calories += entry.Calories * factor
+				nutrition.Total.Protein += entry.Protein * factor
+				nutrition.Total.Carbs += entry.Carbs * factor
+				nutrition.Total.Fat += entry.Fat * factor
+				counted = true
+			}
+			break
+		}
+		if !counted {
+			nutrition.Unknown = append(nutrition.Unknown, ingredient.text)
+		}
+	}
+
+	if recipe.Servings > 0 {
+		perServing := divideFacts(nutrition.Total, float64(recipe.Servings))
+		nutrition.PerServing = &perServing
+	}
+	nutrition.Total = divideFacts(nutrition.Total, 1)
+	return nutrition, nil
+}
+
+// divideFacts divides facts by n, rounded to one decimal.
+func divideFacts(facts models.NutritionFacts, n float64) models.NutritionFacts {
+	round := func(value float64) float64 {
+		return math.Round(value/n*10) / 10
+	}
+	return models.NutritionFacts{
+		Calories: round(facts.Calories),
+		Protein:  round(facts.Protein),
+		Carbs:    round(facts.Carbs),
+		Fat:      round(facts.Fat),
+	}
+}
+
+// swagger:operation GET /recipes/{id}/nutrition recipes getRecipeNutrition
+// Returns the nutrition facts of a recipe
+//
+// The quantities of the ingredients are read from their text, such as
+// "2 tbsp olive oil", and looked up in the nutrition table. Ingredients
+// missing from the table or without a quantity are listed as unknown and
+// left out of the totals.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid recipe ID
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) NutritionHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+
+	// invalidateCache drops the summaries along with the recipe, of which
+	// each organization also has its own copy
+	cacheKey, org := "nutrition:"+id, orgOf(c)
+	if handler.cacheTTL > 0 {
+		val, err := handler.redisClient.HGet(cacheKey, org).Result()
+		if err == nil {
+			var nutrition models.Nutrition
+			json.Unmarshal([]byte(val), &nutrition)
+			c.Header("X-Cache", "HIT")
+			c.JSON(http.StatusOK, nutrition)
+			return
+		}
+		if err != redis.Nil {
+			loggerFrom(c.Request.Context()).Warn("Failed to read nutrition from cache", "error", err)
+		}
+	}
+
+	var recipe models.Recipe
+	err := handler.collection.FindOne(c.Request.Context(), bson.M{
+		"_id":       objectId,
+		"orgId":     org,
+		"deletedAt": notDeleted,
+	}, options.FindOne().SetProjection(bson.M{"ingredients": 1, "servings": 1})).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	nutrition, err := handler.computeNutrition(c.Request.Context(), recipe)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if handler.cacheTTL > 0 {
+		data, _ := json.Marshal(nutrition)
+		handler.cacheVariant(cacheKey, org, string(data), handler.cacheTTL)
+	}
+	c.Header("X-Cache", "MISS")
+	c.JSON(http.StatusOK, nutrition)
+}
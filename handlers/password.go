@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+const resetTTL = 30 * time.Minute
+
+type forgotPasswordRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email" binding:"omitempty,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+	SignOutOthers   bool   `json:"signOutOthers"`
+}
+
+// swagger:operation POST /password/forgot auth forgotPassword
+// Email a password reset link
+//
+// The answer is the same whether or not the account exists, so the endpoint
+// can't be used to find out which usernames or emails are registered.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: Account to reset
+//     required: true
+//     schema: {$ref: '#/definitions/ForgotPassword'}
+//
+// responses:
+//
+//	'200':
+//	    description: Reset link sent if the account exists
+//	'400':
+//	    description: Neither username nor email given
+//	'429':
+//	    $ref: '#/responses/tooManyRequests'
+func (handler *AuthHandler) ForgotPasswordHandler(c *gin.Context) {
+	var request forgotPasswordRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+	if request.Username == "" && request.Email == "" {
+		respondError(c, http.StatusBadRequest, "bad_request", "Username or email is required")
+		return
+	}
+
+	filter := bson.M{"username": request.Username}
+	if request.Username == "" {
+		filter = bson.M{"email": request.Email}
+	}
+	var user models.User
+	err := handler.collection.FindOne(c.Request.Context(), filter).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		respondDBError(c, err)
+		return
+	}
+	if err == nil && user.Email != "" {
+		// sending happens in the background so the response time doesn't
+		// reveal whether the account exists
+		logger := loggerFrom(c.Request.Context())
+		go func() {
+			if err := handler.sendPasswordReset(user); err != nil {
+				logger.Error("Failed to send password reset email", "error", err)
+			}
+		}()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists, a reset link has been sent to its email address"})
+}
+
+// sendPasswordReset stores a single-use reset token for the user in Redis
+// and emails it.
+func (handler *AuthHandler) sendPasswordReset(user models.User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err := handler.redisClient.Set("reset:"+token, user.Username, resetTTL).Err(); err != nil {
+		return err
+	}
+	link := handler.publicURL + "/password/reset?token=" + token
+	return handler.mailer.Send(user.Email, "Reset your password",
+		fmt.Sprintf("Hi %s,\n\nfollow this link within %s to choose a new password:\n%s\n\nIgnore this email if you didn't ask for it.\n", user.Username, resetTTL, link))
+}
+
+// swagger:operation POST /password/reset auth resetPassword
+// Set a new password with a reset token
+//
+// The token can only be used once. Resetting also confirms the email address
+// and lifts a lockout.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: Token and new password
+//     required: true
+//     schema: {$ref: '#/definitions/ResetPassword'}
+//
+// responses:
+//
+//	'200':
+//	    description: Password changed
+//	'400':
+//	    description: Invalid input, invalid or expired token, or password not following the policy
+//	'429':
+//	    $ref: '#/responses/tooManyRequests'
+func (handler *AuthHandler) ResetPasswordHandler(c *gin.Context) {
+	var request resetPasswordRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+	if !handler.checkPassword(c, "password", request.Password) {
+		return
+	}
+
+	// reading and deleting in one transaction makes the token single-use
+	// even when two resets race
+	key := "reset:" + request.Token
+	pipe := handler.redisClient.TxPipeline()
+	get := pipe.Get(key)
+	pipe.Del(key)
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		respondDBError(c, err)
+		return
+	}
+	username, err := get.Result()
+	if err == redis.Nil {
+		respondError(c, http.StatusBadRequest, "bad_request", "Invalid or expired reset token")
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	result, err := handler.collection.UpdateOne(c.Request.Context(), bson.M{
+		"username": username,
+	}, bson.M{
+		"$set":   bson.M{"password": string(hashedPassword)},
+		"$unset": bson.M{"unverified": ""},
+	})
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		respondError(c, http.StatusBadRequest, "bad_request", "Invalid or expired reset token")
+		return
+	}
+	handler.redisClient.Del("login:failures:" + username)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been changed"})
+}
+
+// swagger:operation POST /me/password users changePassword
+// Change the password of the signed in user
+//
+// Wrong current passwords count towards the lockout like failed sign ins.
+// With signOutOthers every other session and token of the user ends; in JWT
+// mode the response then carries a new token replacing the one sent.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: Current and new password
+//     required: true
+//     schema: {$ref: '#/definitions/ChangePassword'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//
+// responses:
+//
+//	'200':
+//	    description: Password changed
+//	'400':
+//	    description: Invalid input, or new password not following the policy or unchanged
+//	'403':
+//	    description: Wrong current password, or signed in with an API key
+//	'423':
+//	    description: Account is locked after too many failed attempts
+func (handler *AuthHandler) ChangePasswordHandler(c *gin.Context) {
+	if _, ok := c.Get("apiKeyId"); ok {
+		respondError(c, http.StatusForbidden, "forbidden", "API keys have no password to change")
+		return
+	}
+	// the bearer token is issued again below, refuse a malformed one before
+	// anything changes
+	var tokenValue string
+	if c.GetHeader("Authorization") != "" {
+		var ok bool
+		if tokenValue, ok = bearerToken(c); !ok {
+			return
+		}
+	}
+	var request changePasswordRequest
+	if !bindJSON(c, &request) {
+		return
+	}
+	if !handler.checkPassword(c, "newPassword", request.NewPassword) {
+		return
+	}
+	if request.NewPassword == request.CurrentPassword {
+		respondError(c, http.StatusBadRequest, "bad_request", "The new password must differ from the current one")
+		return
+	}
+
+	user, _ := CurrentUser(c)
+	_, status, err := handler.checkCredentials(c.Request.Context(), models.User{Username: user.Username, Password: request.CurrentPassword})
+	if status == http.StatusUnauthorized {
+		respondError(c, http.StatusForbidden, "forbidden", "Current password is wrong")
+		return
+	}
+	if err != nil {
+		respondError(c, status, errorCode(status), err.Error())
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	if _, err := handler.collection.UpdateOne(c.Request.Context(), bson.M{
+		"username": user.Username,
+	}, bson.M{"$set": bson.M{"password": string(hashedPassword)}}); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	response := gin.H{"message": "Password has been changed"}
+	if request.SignOutOthers {
+		if err := handler.signOutEverywhere(user.Username); err != nil {
+			respondDBError(c, err)
+			return
+		}
+		// the session or token of this request ended too, issue it again
+		session := sessions.Default(c)
+		if session.Get("token") != nil {
+			session.Set("issuedAt", time.Now().Unix())
+			session.Save()
+		}
+		if tokenValue != "" {
+			claims := &Claims{}
+			if _, err := jwt.ParseWithClaims(tokenValue, claims, handler.keyFunc); err == nil {
+				if err := handler.revokeToken(claims); err != nil {
+					respondDBError(c, err)
+					return
+				}
+				jwtOutput, err := handler.issueToken(claims)
+				if err != nil {
+					respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+					return
+				}
+				response["token"], response["expires"] = jwtOutput.Token, jwtOutput.Expires
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPasswordBytes is the longest password bcrypt hashes, it refuses longer
+// ones rather than ignoring the rest.
+const maxPasswordBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords is the set of the common_passwords.txt entries.
+var commonPasswords = func() map[string]bool {
+	set := map[string]bool{}
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			set[line] = true
+		}
+	}
+	return set
+}()
+
+// PasswordPolicy is what a password needs to be accepted for an account, on
+// sign up, reset and change. MinLength counts characters, not bytes.
+type PasswordPolicy struct {
+	MinLength     int  `json:"minLength"`
+	MaxLength     int  `json:"maxLength"`
+	RequireLower  bool `json:"requireLower"`
+	RequireUpper  bool `json:"requireUpper"`
+	RequireDigit  bool `json:"requireDigit"`
+	RequireSymbol bool `json:"requireSymbol"`
+	RejectCommon  bool `json:"rejectCommon"`
+}
+
+// NewPasswordPolicy returns the policy with the given rules. MaxLength is
+// always the bcrypt limit.
+func NewPasswordPolicy(minLength int, requireLower, requireUpper, requireDigit, requireSymbol, rejectCommon bool) PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     minLength,
+		MaxLength:     maxPasswordBytes,
+		RequireLower:  requireLower,
+		RequireUpper:  requireUpper,
+		RequireDigit:  requireDigit,
+		RequireSymbol: requireSymbol,
+		RejectCommon:  rejectCommon,
+	}
+}
+
+// Check lists the rules password breaks, named after the policy fields:
+// minLength, maxLength, lower, upper, digit, symbol and common. It is empty
+// when the password is accepted.
+func (policy PasswordPolicy) Check(password string) []FieldError {
+	var broken []FieldError
+	fail := func(rule, format string, args ...interface{}) {
+		broken = append(broken, FieldError{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		fail("minLength", "must be at least %d characters long", policy.MinLength)
+	}
+	if len(password) > policy.MaxLength {
+		fail("maxLength", "must be at most %d bytes long", policy.MaxLength)
+	}
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if policy.RequireLower && !lower {
+		fail("lower", "must contain a lower case letter")
+	}
+	if policy.RequireUpper && !upper {
+		fail("upper", "must contain an upper case letter")
+	}
+	if policy.RequireDigit && !digit {
+		fail("digit", "must contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		fail("symbol", "must contain a symbol or punctuation")
+	}
+	if policy.RejectCommon && commonPasswords[strings.ToLower(password)] {
+		fail("common", "is too common")
+	}
+	return broken
+}
+
+// checkPassword answers with a weak_password 400 listing the broken rules,
+// reported on field, when password doesn't follow the policy.
+func (handler *AuthHandler) checkPassword(c *gin.Context, field, password string) bool {
+	broken := handler.passwordPolicy.Check(password)
+	if len(broken) == 0 {
+		return true
+	}
+	for i := range broken {
+		broken[i].Field = field
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": APIError{
+		Code:      "weak_password",
+		Message:   "The password doesn't follow the password policy",
+		RequestID: GetRequestID(c),
+		Fields:    broken,
+	}})
+	return false
+}
+
+// swagger:operation GET /password/policy auth passwordPolicy
+// Describe the rules passwords must follow
+//
+// Lets clients tell users what is required and check passwords before
+// sending them. The list of common passwords itself isn't published.
+// ---
+// produces:
+// - application/json
+// responses:
+//
+//	'200':
+//	    description: The password policy
+func (handler *AuthHandler) PasswordPolicyHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, handler.passwordPolicy)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// patchableFields are the recipe fields a PATCH may change, mapped to whether
+// an explicit null clears them. Required fields can't be cleared.
+var patchableFields = map[string]bool{
+	"name":         false,
+	"ingredients":  false,
+	"instructions": false,
+	"tags":         true,
+	"cuisine":      true,
+	"servings":     true,
+	"difficulty":   true,
+	"prepTime":     true,
+	"cookTime":     true,
+}
+
+// swagger:operation PATCH /recipes/{id} recipes patchRecipe
+// Partially update a recipe
+//
+// Only the fields present in the body are changed, the others keep their
+// value. A field set to null is cleared, which only tags, cuisine, servings,
+// difficulty and the times allow.
+// The version the client last read must be sent like for PUT.
+// ---
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: If-Match
+//     in: header
+//     description: ETag or version of the recipe as last read
+//     required: false
+//     type: string
+//   - name: body
+//     in: body
+//     description: Fields to change
+//     required: true
+//     schema: {$ref: '#/definitions/RecipePatch'}
+//
+// produces:
+// - application/json
+// - application/xml
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The updated recipe
+//	'400':
+//	    description: Invalid input
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: Recipe not found
+//	'409':
+//	    description: The recipe was modified since the expected version
+//	'428':
+//	    description: Expected version missing
+func (handler *RecipesHandler) PatchRecipeHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	var body map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	var current models.Recipe
+	if raw, ok := body["version"]; ok {
+		if err := json.Unmarshal(raw, &current.Version); err != nil {
+			respondValidationError(c, []FieldError{{Field: "version", Message: "must be an integer"}})
+			return
+		}
+		delete(body, "version")
+	}
+	set, unset, fieldErrors := parsePatch(body)
+	if len(fieldErrors) > 0 {
+		respondValidationError(c, fieldErrors)
+		return
+	}
+	if len(set) == 0 && len(unset) == 0 {
+		respondError(c, http.StatusBadRequest, "bad_request", "Nothing to update")
+		return
+	}
+	version, ok := expectedVersion(c, current)
+	if !ok {
+		return
+	}
+	if !handler.authorizeOwner(c, objectId) {
+		return
+	}
+
+	update := bson.D{
+		{Key: "$inc", Value: bson.M{"version": 1}},
+		{Key: "$set", Value: append(set, bson.E{Key: "updatedAt", Value: time.Now()})},
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	var recipe models.Recipe
+	err := handler.collection.FindOneAndUpdate(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+		"version":   versionFilter(version),
+	}), update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		handler.versionMismatch(c, objectId)
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	handler.invalidateCache(id)
+	handler.audit(c, "update", objectId, recipeSnapshot(recipe))
+
+	handler.respond(c, http.StatusOK, recipe)
+}
+
+// parsePatch turns the fields of a PATCH body into the $set and $unset
+// documents, listing every field it can't apply.
+func parsePatch(body map[string]json.RawMessage) (bson.D, bson.D, []FieldError) {
+	fields := make([]string, 0, len(body))
+	for field := range body {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var set, unset bson.D
+	fieldErrors := make([]FieldError, 0)
+	for _, field := range fields {
+		raw := body[field]
+		clearable, ok := patchableFields[field]
+		if !ok {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "cannot be updated"})
+			continue
+		}
+		if string(raw) == "null" {
+			if !clearable {
+				fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "cannot be cleared"})
+				continue
+			}
+			unset = append(unset, bson.E{Key: field, Value: ""})
+			continue
+		}
+
+		if field == "name" || field == "cuisine" {
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil || strings.TrimSpace(value) == "" {
+				fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "must be a non-empty string"})
+				continue
+			}
+			set = append(set, bson.E{Key: field, Value: value})
+			continue
+		}
+
+		if field == "difficulty" {
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil || !difficulties[value] {
+				fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "must be one of easy, medium or hard"})
+				continue
+			}
+			set = append(set, bson.E{Key: field, Value: value})
+			continue
+		}
+
+		if field == "prepTime" || field == "cookTime" {
+			var value int
+			if err := json.Unmarshal(raw, &value); err != nil || value < 1 {
+				fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "must be a positive number of minutes"})
+				continue
+			}
+			set = append(set, bson.E{Key: field, Value: value})
+			continue
+		}
+
+		if field == "servings" {
+			var value int
+			if err := json.Unmarshal(raw, &value); err != nil || value < 1 || value > maxServings {
+				fieldErrors = append(fieldErrors, FieldError{Field: field, Message: fmt.Sprintf("must be an integer between 1 and %d", maxServings)})
+				continue
+			}
+			set = append(set, bson.E{Key: field, Value: value})
+			continue
+		}
+
+		var values []string
+		if err := json.Unmarshal(raw, &values); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "must be a list of strings"})
+			continue
+		}
+		if !clearable && len(values) == 0 {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "must contain at least 1 item(s)"})
+			continue
+		}
+		set = append(set, bson.E{Key: field, Value: values})
+	}
+	return set, unset, fieldErrors
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// recipeText lays out a recipe for printing.
+var recipeText = template.Must(template.New("recipe").Funcs(template.FuncMap{
+	"underline": func(s string) string { return strings.Repeat("=", len([]rune(s))) },
+	"inc":       func(i int) int { return i + 1 },
+	"join":      strings.Join,
+}).Parse(`{{.Name}}
+{{underline .Name}}
+{{if or .Cuisine .Servings .Difficulty .PrepTime .CookTime .Tags}}
+{{if .Cuisine}}Cuisine: {{.Cuisine}}
+{{end}}{{if .Servings}}Serves: {{.Servings}}
+{{end}}{{if .Difficulty}}Difficulty: {{.Difficulty}}
+{{end}}{{if .PrepTime}}Prep: {{.PrepTime}} min
+{{end}}{{if .CookTime}}Cook: {{.CookTime}} min
+{{end}}{{if .Tags}}Tags: {{join .Tags ", "}}
+{{end}}{{end}}
+Ingredients
+-----------
+{{range .Ingredients}}- {{.}}
+{{end}}
+Instructions
+------------
+{{range $i, $step := .Instructions}}{{inc $i}}. {{$step}}
+{{end}}`))
+
+// wantsText tells whether GET /recipes/:id should answer with the printable
+// text: for an id ending in .txt or when the client prefers text/plain. JSON
+// stays the default.
+func (handler *RecipesHandler) wantsText(c *gin.Context, id string) (string, bool) {
+	if trimmed, ok := strings.CutSuffix(id, ".txt"); ok {
+		return trimmed, true
+	}
+	varyOnAccept(c)
+	return id, c.NegotiateFormat(handler.formats(binding.MIMEPlain)...) == binding.MIMEPlain
+}
+
+// respondRecipeText renders the recipe with recipeText.
+func respondRecipeText(c *gin.Context, recipe models.Recipe) {
+	var text bytes.Buffer
+	if err := recipeText.Execute(&text, recipe); err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Error while rendering the recipe")
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", text.Bytes())
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// tokenBucket refills a bucket of ARGV[1] tokens evenly over ARGV[2]
+// milliseconds and tries to take one token from it. It returns whether a token
+// was taken, how many milliseconds to wait before the next one is available,
+// the whole tokens left and the milliseconds until the bucket is full again.
+// Running it as a script keeps the read-modify-write atomic across instances,
+// so the state reported is the one this request left, whatever runs alongside.
+// The time comes from the Redis clock too, so instances whose clocks drift
+// apart still refill the bucket at the same pace.
+var tokenBucket = redis.NewScript(`
+-- replicate the writes rather than the script, which reads the clock
+redis.replicate_commands()
+local capacity = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1]) or capacity
+local ts = tonumber(bucket[2]) or now
+
+tokens = math.min(capacity, tokens + (now - ts) * capacity / window)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], window)
+
+local retry = 0
+if allowed == 0 then
+	retry = math.ceil((1 - tokens) * window / capacity)
+end
+local reset = math.ceil((capacity - tokens) * window / capacity)
+return {allowed, retry, math.floor(tokens), reset}
+`)
+
+// tooManyRequests is the answer of the rate limiter once the limit is used up.
+//
+// swagger:response tooManyRequests
+type tooManyRequests struct {
+	// requests allowed per RATE_WINDOW, or the limit of the API key
+	// in: header
+	Limit int `json:"X-RateLimit-Limit"`
+	// requests that can still be made right away
+	// in: header
+	Remaining int `json:"X-RateLimit-Remaining"`
+	// seconds until the whole limit is available again
+	// in: header
+	Reset int `json:"X-RateLimit-Reset"`
+	// seconds until the next request is allowed
+	// in: header
+	RetryAfter int `json:"Retry-After"`
+	// in: body
+	Body struct {
+		Error APIError `json:"error"`
+	}
+}
+
+type RateLimiter struct {
+	redisClient *redis.Client
+	limit       int64
+	window      time.Duration
+}
+
+func NewRateLimiter(redisClient *redis.Client, limit int64, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		redisClient: redisClient,
+		limit:       limit,
+		window:      window,
+	}
+}
+
+// Middleware allows limit requests per window for each user, or for each
+// client IP on routes without authentication. API keys are limited on their
+// own, to the limit set on the key if any. When Redis is unavailable requests
+// are let through rather than failing the whole API.
+//
+// Every answer carries X-RateLimit-Limit, X-RateLimit-Remaining, the requests
+// that can still be made right away, and X-RateLimit-Reset, the seconds until
+// all of them can again, as the API description in main.go tells clients.
+func (limiter *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ratelimit:ip:" + c.ClientIP()
+		if user, ok := CurrentUser(c); ok && user.Username != "" {
+			key = "ratelimit:user:" + user.Username
+		}
+		limit := limiter.limit
+		if apiKeyId := c.GetString("apiKeyId"); apiKeyId != "" {
+			key = "ratelimit:apikey:" + apiKeyId
+			if keyLimit := c.GetInt64("rateLimit"); keyLimit > 0 {
+				limit = keyLimit
+			}
+		}
+
+		result, err := tokenBucket.Run(limiter.redisClient, []string{key},
+			limit, limiter.window.Milliseconds()).Result()
+		if err != nil {
+			loggerFrom(c.Request.Context()).Error("Rate limiter unavailable", "error", err)
+			c.Next()
+			return
+		}
+
+		values := result.([]interface{})
+		reset := time.Duration(values[3].(int64)) * time.Millisecond
+		c.Header("X-RateLimit-Limit", strconv.FormatInt(limit, 10))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(values[2].(int64), 10))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+		if values[0].(int64) == 0 {
+			retryAfter := time.Duration(values[1].(int64)) * time.Millisecond
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			respondError(c, http.StatusTooManyRequests, "rate_limited", fmt.Sprintf("Rate limit exceeded, retry in %s", retryAfter.Round(time.Second)))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterFollowsRedisClock(t *testing.T) {
+	redisClient, redisServer := testRedis(t)
+	router := gin.New()
+	router.Use(NewRateLimiter(redisClient, 2, time.Minute).Middleware())
+	router.GET("/recipes", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	start := time.Now()
+	redisServer.SetTime(start)
+	for i, want := range []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests} {
+		if w := serve(router, http.MethodGet, "/recipes", ""); w.Code != want {
+			t.Fatalf("request %d: got %d, want %d", i+1, w.Code, want)
+		}
+	}
+
+	// half the window later on the Redis clock one token is back, whatever
+	// the clock of this instance says
+	redisServer.SetTime(start.Add(30 * time.Second))
+	w := serve(router, http.MethodGet, "/recipes", "")
+	if w.Code != http.StatusNoContent || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("after the refill: got %d with %q remaining, want 204 and 0", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+}
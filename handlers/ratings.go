@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ratings is the collection holding one {username, recipeId, score}
+// document per rating, unique per user and recipe.
+func (handler *RecipesHandler) ratings() *mongo.Collection {
+	return handler.collection.Database().Collection("ratings")
+}
+
+// swagger:operation POST /recipes/{id}/rating ratings rateRecipe
+// Rate a recipe from 1 to 5
+//
+// Rating again replaces the previous rating of the user. The average and
+// count stored on the recipe are recomputed from all its ratings.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     description: The rating
+//     required: true
+//     schema: {$ref: '#/definitions/Rating'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The new average and number of ratings
+//	'400':
+//	    description: Invalid recipe ID or score
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) RateRecipeHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	var body struct {
+		Score int `json:"score" binding:"required,min=1,max=5"`
+	}
+	if !bindJSON(c, &body) {
+		return
+	}
+	count, err := handler.collection.CountDocuments(c.Request.Context(), inOrg(c, bson.M{
+		"_id":       objectId,
+		"deletedAt": notDeleted,
+	}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if count == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+
+	user, _ := CurrentUser(c)
+	_, err = handler.ratings().UpdateOne(c.Request.Context(), bson.M{
+		"username": user.Username,
+		"recipeId": objectId,
+	}, bson.M{
+		"$set": bson.M{"score": body.Score, "updatedAt": time.Now()},
+	}, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		respondDBError(c, err)
+		return
+	}
+
+	avg, total, err := handler.updateRating(c, objectId)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	handler.invalidateCache(id)
+
+	c.JSON(http.StatusOK, gin.H{"score": body.Score, "avgRating": avg, "ratingCount": total})
+}
+
+// updateRating recomputes the average and count of the ratings of a recipe
+// and stores them on it. Recomputing rather than adjusting the stored values
+// keeps them right when ratings race.
+func (handler *RecipesHandler) updateRating(c *gin.Context, recipeId primitive.ObjectID) (float64, int64, error) {
+	cur, err := handler.ratings().Aggregate(c.Request.Context(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"recipeId": recipeId}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"avg":   bson.M{"$avg": "$score"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cur.Close(c.Request.Context())
+
+	var result struct {
+		Avg   float64 `bson:"avg"`
+		Count int64   `bson:"count"`
+	}
+	if cur.Next(c.Request.Context()) {
+		if err := cur.Decode(&result); err != nil {
+			return 0, 0, err
+		}
+	}
+	avg := math.Round(result.Avg*100) / 100
+
+	_, err = handler.collection.UpdateOne(c.Request.Context(), bson.M{"_id": recipeId}, bson.M{
+		"$set": bson.M{"avgRating": avg, "ratingCount": result.Count},
+	})
+	return avg, result.Count, err
+}
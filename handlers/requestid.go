@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds the ids taken from clients, which end up in
+	// every log line of the request
+	maxRequestIDLength = 64
+)
+
+// RequestID tags every request with an id, reusing the one sent by the client
+// in X-Request-ID when it is at most 64 token characters, as in an HTTP header
+// name. Anything else is replaced, so clients can't forge log lines or flood
+// them. The id is echoed back in the same header.
+// A nil generator defaults to xid; tests can pass a deterministic one.
+func RequestID(generator func() string) gin.HandlerFunc {
+	if generator == nil {
+		generator = func() string {
+			return xid.New().String()
+		}
+	}
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = generator()
+		}
+		c.Set("requestId", id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// validRequestID reports whether id is fit to be reused as the request id.
+func validRequestID(id string) bool {
+	return id != "" && len(id) <= maxRequestIDLength && strings.IndexFunc(id, func(r rune) bool {
+		return !httpguts.IsTokenRune(r)
+	}) == -1
+}
+
+// GetRequestID returns the id assigned to the request by RequestID.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString("requestId")
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestID(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestID(func() string { return "generated" }))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, GetRequestID(c))
+	})
+
+	tests := []struct {
+		name string
+		sent string
+		want string
+	}{
+		{"none sent", "", "generated"},
+		{"token characters", "req-42_a.b~c", "req-42_a.b~c"},
+		{"64 characters", strings.Repeat("a", 64), strings.Repeat("a", 64)},
+		{"too long", strings.Repeat("a", 65), "generated"},
+		{"space", "forged id", "generated"},
+		{"quote", `id"`, "generated"},
+		{"non ASCII", "idé", "generated"},
+	}
+	for _, test := range tests {
+		var headers []string
+		if test.sent != "" {
+			headers = []string{requestIDHeader, test.sent}
+		}
+		w := serve(router, http.MethodGet, "/", "", headers...)
+		if w.Body.String() != test.want || w.Header().Get(requestIDHeader) != test.want {
+			t.Errorf("%s: got %q and header %q, want %q", test.name, w.Body, w.Header().Get(requestIDHeader), test.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+const maxServings = 1000
+
+// leadingQuantity matches the amount an ingredient starts with: a whole or
+// decimal number, a fraction or a whole number followed by a fraction, as in
+// "2 eggs", "0.5 l milk", "1/2 tsp salt" or "1 1/2 cups flour".
+var leadingQuantity = regexp.MustCompile(`^(\d+\s+\d+/\d+|\d+/\d+|\d+(?:\.\d+)?)`)
+
+// commonFractions are the fractions cooks write, a scaled amount close to
+// one of them is written as such rather than as a decimal.
+var commonFractions = []struct {
+	value float64
+	text  string
+}{
+	{1.0 / 8, "1/8"}, {1.0 / 4, "1/4"}, {1.0 / 3, "1/3"}, {3.0 / 8, "3/8"},
+	{1.0 / 2, "1/2"}, {5.0 / 8, "5/8"}, {2.0 / 3, "2/3"}, {3.0 / 4, "3/4"}, {7.0 / 8, "7/8"},
+}
+
+// parseServings reads the servings query parameter, 0 when it is absent. It
+// answers 400 and returns false when the value is invalid.
+func parseServings(c *gin.Context) (int, bool) {
+	value := c.Query("servings")
+	if value == "" {
+		return 0, true
+	}
+	servings, err := strconv.Atoi(value)
+	if err != nil || servings < 1 || servings > maxServings {
+		respondError(c, http.StatusBadRequest, "bad_request", fmt.Sprintf("servings must be an integer between 1 and %d", maxServings))
+		return 0, false
+	}
+	return servings, true
+}
+
+// scaleRecipe multiplies the quantities of the ingredients by servings over
+// the servings of the recipe. It answers 422 and returns false for a recipe
+// that doesn't say how many it serves.
+func scaleRecipe(c *gin.Context, recipe *models.Recipe, servings int) bool {
+	if servings == 0 || servings == recipe.Servings {
+		return true
+	}
+	if recipe.Servings == 0 {
+		respondError(c, http.StatusUnprocessableEntity, "unprocessable_entity", "The recipe doesn't say how many servings it makes, it can't be scaled")
+		return false
+	}
+
+	factor := float64(servings) / float64(recipe.Servings)
+	scaled := make([]string, len(recipe.Ingredients))
+	for i, ingredient := range recipe.Ingredients {
+		scaled[i] = scaleIngredient(ingredient, factor)
+	}
+	recipe.Ingredients = scaled
+	recipe.Servings = servings
+	return true
+}
+
+// scaleIngredient scales the quantity an ingredient starts with, leaving
+// ingredients without one, such as "salt to taste", unchanged.
+func scaleIngredient(ingredient string, factor float64) string {
+	match := leadingQuantity.FindString(ingredient)
+	if match == "" {
+		return ingredient
+	}
+	quantity, ok := parseQuantity(match)
+	if !ok {
+		return ingredient
+	}
+	return formatQuantity(quantity*factor) + ingredient[len(match):]
+}
+
+func parseQuantity(text string) (float64, bool) {
+	whole := 0.0
+	if fields := strings.Fields(text); len(fields) == 2 {
+		whole, _ = strconv.ParseFloat(fields[0], 64)
+		text = fields[1]
+	}
+	numerator, denominator, isFraction := strings.Cut(text, "/")
+	if !isFraction {
+		value, err := strconv.ParseFloat(text, 64)
+		return value, err == nil
+	}
+	n, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0, false
+	}
+	d, err := strconv.ParseFloat(denominator, 64)
+	if err != nil || d == 0 {
+		return 0, false
+	}
+	return whole + n/d, true
+}
+
+// formatQuantity writes an amount the way recipes do: "3", "1 1/2" or "2/3",
+// falling back to at most two decimals when no common fraction is close.
+func formatQuantity(quantity float64) string {
+	whole := math.Floor(quantity)
+	fraction := quantity - whole
+	if fraction < 0.02 {
+		return strconv.FormatFloat(whole, 'f', -1, 64)
+	}
+	if fraction > 0.98 {
+		return strconv.FormatFloat(whole+1, 'f', -1, 64)
+	}
+	for _, common := range commonFractions {
+		if math.Abs(fraction-common.value) < 0.02 {
+			if whole == 0 {
+				return common.text
+			}
+			return strconv.FormatFloat(whole, 'f', -1, 64) + " " + common.text
+		}
+	}
+	return strconv.FormatFloat(math.Round(quantity*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// normalizeName is the form of a recipe name compared to find duplicates,
+// so "Pancakes " and "pancakes" are the same recipe.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// ingredientKeys reduces ingredients to their names, without the quantity,
+// the unit or the preparation and in the singular, so "2 cups flour" and
+// "250 g flour, sifted" are the same ingredient.
+func ingredientKeys(ingredients []string) map[string]bool {
+	keys := make(map[string]bool, len(ingredients))
+	for _, text := range ingredients {
+		words := parseIngredient(text).words
+		if len(words) == 0 {
+			continue
+		}
+		keys[strings.TrimSuffix(strings.Join(words, " "), "s")] = true
+	}
+	return keys
+}
+
+// findDuplicate returns a recipe of the same organization that recipe likely
+// duplicates: one with the same normalized name sharing at least
+// duplicateThreshold of the ingredients of recipe. It returns nil when there is none.
+func (handler *RecipesHandler) findDuplicate(ctx context.Context, recipe models.Recipe) (*models.Recipe, error) {
+	cur, err := handler.collection.Find(ctx, bson.M{
+		"name":      bson.M{"$regex": `^\s*` + regexp.QuoteMeta(normalizeName(recipe.Name)) + `\s*$`, "$options": "i"},
+		"orgId":     recipe.OrgID,
+		"deletedAt": notDeleted,
+	}, options.Find().SetProjection(bson.M{"name": 1, "ingredients": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.Recipe
+	if err := cur.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	keys := ingredientKeys(recipe.Ingredients)
+	for _, candidate := range candidates {
+		existing := ingredientKeys(candidate.Ingredients)
+		shared := 0
+		for key := range keys {
+			if existing[key] {
+				shared++
+			}
+		}
+		if len(keys) == 0 || float64(shared)/float64(len(keys)) >= handler.duplicateThreshold {
+			return &candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+// respondDuplicate answers 409 pointing at the recipe the request duplicates,
+// both in the Location header and in the body.
+func respondDuplicate(c *gin.Context, existing *models.Recipe) {
+	id := existing.ID.Hex()
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+id)
+	c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": APIError{
+		Code:      "duplicate_recipe",
+		Message:   "A recipe with the same name and ingredients already exists, send force=true to create it anyway",
+		RequestID: GetRequestID(c),
+		RecipeID:  id,
+	}})
+}
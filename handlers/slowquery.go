@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"golang.org/x/net/context"
+)
+
+// slowQueryFields are the fields of a command holding what it matches on,
+// looked up in this order.
+var slowQueryFields = []string{"filter", "query", "pipeline", "updates", "deletes"}
+
+// slowCommand is what is kept of a started command until it is known whether
+// it was slow.
+type slowCommand struct {
+	collection string
+	filter     bson.RawValue
+}
+
+// SlowQueryMonitor times every MongoDB command and logs a warning for those
+// that take longer than threshold, with the command name, the collection and
+// the filter. The line goes through the logger of the request the command
+// ran for, which tags it with the request id. Commands are passed on to next,
+// the monitor the client would have had otherwise.
+//
+// getMore is left out: the change stream of WatchChanges spends most of its
+// time waiting in it.
+func SlowQueryMonitor(threshold time.Duration, next *event.CommandMonitor) *event.CommandMonitor {
+	var started sync.Map
+	finished := func(ctx context.Context, e event.CommandFinishedEvent, failure string) {
+		value, ok := started.LoadAndDelete(e.RequestID)
+		if !ok || e.Duration < threshold {
+			return
+		}
+		command := value.(slowCommand)
+		args := []interface{}{
+			"command", e.CommandName,
+			"collection", command.collection,
+			"durationMs", float64(e.Duration.Microseconds()) / 1000,
+		}
+		if command.filter.Type != 0 {
+			args = append(args, "filter", command.filter.String())
+		}
+		if failure != "" {
+			args = append(args, "error", failure)
+		}
+		loggerFrom(ctx).Warn("Slow MongoDB query", args...)
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			if e.CommandName != "getMore" {
+				command := slowCommand{}
+				command.collection, _ = e.Command.Lookup(e.CommandName).StringValueOK()
+				for _, field := range slowQueryFields {
+					if value, err := e.Command.LookupErr(field); err == nil {
+						// the command may be reused once sent, keep a copy
+						command.filter = bson.RawValue{Type: value.Type, Value: append([]byte(nil), value.Value...)}
+						break
+					}
+				}
+				started.Store(e.RequestID, command)
+			}
+			if next != nil && next.Started != nil {
+				next.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			finished(ctx, e.CommandFinishedEvent, "")
+			if next != nil && next.Succeeded != nil {
+				next.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			finished(ctx, e.CommandFinishedEvent, e.Failure)
+			if next != nil && next.Failed != nil {
+				next.Failed(ctx, e)
+			}
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// eventsChannel is the Redis channel the writes on recipes are published
+	// to, so streams on every instance see them
+	eventsChannel = "recipes:events"
+	keepAlive     = 15 * time.Second
+)
+
+// publish sends an encoded models.WebhookEvent to the event streams.
+func (handler *RecipesHandler) publish(body []byte) error {
+	return handler.redisClient.Publish(eventsChannel, body).Err()
+}
+
+// CloseStreams ends the open event streams, so that a graceful shutdown
+// doesn't wait on them.
+func (handler *RecipesHandler) CloseStreams() {
+	handler.closeStreams.Do(func() { close(handler.closing) })
+}
+
+// isEventStream tells whether the client asked for an event stream.
+func isEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// swagger:operation GET /recipes/stream recipes streamRecipes
+// Stream the writes on recipes as Server-Sent Events
+//
+// Every create, update, delete, restore, purge and transfer in the
+// organization is sent as an event named after the action, with the same
+// JSON data as the webhooks. A comment is sent every 15 seconds to keep the
+// connection open.
+// ---
+// produces:
+// - text/event-stream
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: The event stream
+func (handler *RecipesHandler) StreamRecipesHandler(c *gin.Context) {
+	pubsub := handler.redisClient.Subscribe(eventsChannel)
+	defer pubsub.Close()
+	// waiting for the confirmation makes sure no write is missed once the
+	// client sees the stream open
+	if _, err := pubsub.Receive(); err != nil {
+		respondDBError(c, err)
+		return
+	}
+	messages := pubsub.Channel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// tells nginx not to buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.WriteString(": connected\n\n")
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	ctx := c.Request.Context()
+	org := orgOf(c)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-handler.closing:
+			return
+		case <-ticker.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event struct {
+				ID     string `json:"id"`
+				Action string `json:"action"`
+				OrgID  string `json:"orgId"`
+			}
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				loggerFrom(ctx).Warn("Failed to decode recipe event", "error", err)
+				continue
+			}
+			if event.OrgID != org {
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Action, message.Payload); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
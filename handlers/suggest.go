@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// swagger:operation POST /recipes/suggest recipes suggestRecipes
+// Suggest recipes that can be made with the given ingredients
+//
+// Recipes are ranked by the fraction of their ingredients the user has,
+// reported as match. Ingredients are free text such as "1/2 tsp salt", so an
+// ingredient counts as available when it mentions one of the given ones,
+// ignoring case and plurals.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: minMatch
+//     in: query
+//     description: lowest fraction of available ingredients, between 0 and 1
+//     required: false
+//     type: number
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: limit
+//     in: query
+//     description: number of recipes per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//   - name: body
+//     in: body
+//     description: The ingredients the user has
+//     required: true
+//     schema: {$ref: '#/definitions/Suggest'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid ingredients or query parameters
+func (handler *RecipesHandler) SuggestRecipesHandler(c *gin.Context) {
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	minMatch := 0.0
+	if value := c.Query("minMatch"); value != "" {
+		minMatch, err = strconv.ParseFloat(value, 64)
+		if err != nil || minMatch < 0 || minMatch > 1 {
+			respondError(c, http.StatusBadRequest, "bad_request", "minMatch must be a number between 0 and 1")
+			return
+		}
+	}
+	var body struct {
+		Ingredients []string `json:"ingredients" binding:"required,min=1,max=100"`
+	}
+	if !bindJSON(c, &body) {
+		return
+	}
+	terms := make([]string, 0, len(body.Ingredients))
+	for _, ingredient := range body.Ingredients {
+		if ingredient = strings.TrimSpace(ingredient); ingredient != "" {
+			terms = append(terms, regexp.QuoteMeta(ingredient))
+		}
+	}
+	if len(terms) == 0 {
+		respondValidationError(c, []FieldError{{Field: "ingredients", Message: "must contain at least 1 item(s)"}})
+		return
+	}
+	// whole words only, so "oil" doesn't match "boil", with an optional plural
+	pattern := `\b(?:` + strings.Join(terms, "|") + `)(?:e?s)?\b`
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: inOrg(c, bson.M{
+			"deletedAt":   notDeleted,
+			"ingredients": bson.M{"$regex": pattern, "$options": "i"},
+		})}},
+		{{Key: "$addFields", Value: bson.M{"available": bson.M{"$size": bson.M{"$filter": bson.M{
+			"input": "$ingredients",
+			"cond":  bson.M{"$regexMatch": bson.M{"input": "$$this", "regex": pattern, "options": "i"}},
+		}}}}}},
+		{{Key: "$addFields", Value: bson.M{"match": bson.M{"$divide": bson.A{"$available", bson.M{"$size": "$ingredients"}}}}}},
+		{{Key: "$match", Value: bson.M{"match": bson.M{"$gte": minMatch}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "match", Value: -1}, {Key: "available", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "count"}},
+			"data": bson.A{
+				bson.M{"$skip": (page - 1) * limit},
+				bson.M{"$limit": limit},
+			},
+		}}},
+	}
+	cur, err := handler.collection.Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(c.Request.Context())
+
+	var result struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Data []models.Recipe `bson:"data"`
+	}
+	if cur.Next(c.Request.Context()) {
+		if err := cur.Decode(&result); err != nil {
+			respondDBError(c, err)
+			return
+		}
+	}
+
+	list := models.RecipeList{Data: result.Data, Page: page, Limit: limit}
+	if list.Data == nil {
+		list.Data = []models.Recipe{}
+	}
+	if len(result.Total) > 0 {
+		list.Total = result.Total[0].Count
+	}
+	list.TotalPages = (list.Total + limit - 1) / limit
+	setPaginationLinks(c, list)
+	c.JSON(http.StatusOK, list)
+}
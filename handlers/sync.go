@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// syncOverlap moves syncedAt back to cover writes that took their timestamp
+// before the sync started but committed after it, and clock drift between
+// instances. Clients get those recipes twice rather than never.
+const syncOverlap = 5 * time.Second
+
+// tombstones holds one {recipeId, orgId, deletedAt} document per purged
+// recipe, so syncing clients learn about recipes that no longer exist at all.
+func (handler *RecipesHandler) tombstones() *mongo.Collection {
+	return handler.collection.Database().Collection("tombstones")
+}
+
+// syncRecipes answers GET /recipes?modifiedSince= with the recipes created or
+// updated since then and the ids of those deleted since then.
+func (handler *RecipesHandler) syncRecipes(c *gin.Context, value string) {
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", "modifiedSince must be an RFC 3339 time")
+		return
+	}
+	sync := models.RecipeSync{
+		Data:     []models.Recipe{},
+		Deleted:  []string{},
+		SyncedAt: time.Now().Add(-syncOverlap).UTC(),
+	}
+
+	ctx := c.Request.Context()
+	cur, err := handler.collection.Find(ctx, inOrg(c, bson.M{
+		"updatedAt": bson.M{"$gte": since},
+		"deletedAt": notDeleted,
+	}), options.Find().SetSort(bson.D{{Key: "updatedAt", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if err := cur.All(ctx, &sync.Data); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	var deleted []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	cur, err = handler.collection.Find(ctx, inOrg(c, bson.M{"deletedAt": bson.M{"$gte": since}}), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err == nil {
+		err = cur.All(ctx, &deleted)
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	var purged []struct {
+		RecipeID primitive.ObjectID `bson:"recipeId"`
+	}
+	cur, err = handler.tombstones().Find(ctx, inOrg(c, bson.M{"deletedAt": bson.M{"$gte": since}}))
+	if err == nil {
+		err = cur.All(ctx, &purged)
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	for _, recipe := range deleted {
+		sync.Deleted = append(sync.Deleted, recipe.ID.Hex())
+	}
+	for _, tombstone := range purged {
+		sync.Deleted = append(sync.Deleted, tombstone.RecipeID.Hex())
+	}
+
+	handler.respond(c, http.StatusOK, sync)
+}
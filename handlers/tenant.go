@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// DefaultOrg is the organization of the accounts, recipes and keys stored
+	// before organizations existed, and of every new account until a super
+	// admin moves it to another one.
+	DefaultOrg = "default"
+
+	// superAdminRole is the only one allowed across organizations, admins
+	// only administer their own.
+	superAdminRole = "superadmin"
+
+	orgHeader = "X-Org-ID"
+)
+
+// OrgScope lets super admins act in another organization than their own by
+// naming it in the X-Org-ID header, which anyone else is refused. It must be
+// chained after the auth middlewares.
+func OrgScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org := strings.TrimSpace(c.GetHeader(orgHeader))
+		if org == "" {
+			c.Next()
+			return
+		}
+		if !hasRole(c, superAdminRole) {
+			respondError(c, http.StatusForbidden, "forbidden", "Only super admins can act in another organization")
+			return
+		}
+		user, _ := CurrentUser(c)
+		user.OrgID = org
+		setCurrentUser(c, user)
+		c.Next()
+	}
+}
+
+// orgOf returns the organization the request acts in. Sessions and tokens
+// issued before organizations existed belong to DefaultOrg.
+func orgOf(c *gin.Context) string {
+	user, _ := CurrentUser(c)
+	return orgOrDefault(user.OrgID)
+}
+
+// inOrg restricts a filter on recipes, or anything else carrying an orgId,
+// to the organization of the request. It returns filter for chaining.
+func inOrg(c *gin.Context, filter bson.M) bson.M {
+	filter["orgId"] = orgOf(c)
+	return filter
+}
+
+// orgOrDefault returns the orgId stored on a document, which is DefaultOrg
+// for those stored before organizations existed.
+func orgOrDefault(org string) string {
+	if org == "" {
+		return DefaultOrg
+	}
+	return org
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout gives every request a deadline of timeout. It doesn't cut the
+// handler off, which keeps running on the request goroutine: only the work
+// following the request context stops at the deadline. That covers MongoDB,
+// whose driver cancels the query, but not go-redis v6, which takes no
+// context, so a Redis call runs to the client's own read and write
+// timeouts. Whatever is still unanswered when the handler returns gets a
+// 503 here, and a handler that only writes after the deadline is too late:
+// its response is dropped for the 503. Event streams stay open by design and
+// get no deadline.
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isEventStream(c) {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		writer := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.late() {
+			respondError(c, http.StatusServiceUnavailable, "timeout", "Request timed out")
+		}
+	}
+}
+
+// timeoutWriter passes the response through until the deadline. When
+// nothing was written by then, it drops whatever the handler still writes,
+// headers included, like http.TimeoutHandler does.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx       context.Context
+	timedOut  bool
+	discarded http.Header
+}
+
+// late reports whether the deadline passed before the response was started.
+func (w *timeoutWriter) late() bool {
+	if !w.timedOut && !w.ResponseWriter.Written() && w.ctx.Err() == context.DeadlineExceeded {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	if w.late() {
+		if w.discarded == nil {
+			w.discarded = http.Header{}
+		}
+		return w.discarded
+	}
+	return w.ResponseWriter.Header()
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if !w.late() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if !w.late() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.late() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.late() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.WriteString(s)
+}
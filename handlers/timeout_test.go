@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTimeout(t *testing.T) {
+	router := gin.New()
+	router.Use(Timeout(50 * time.Millisecond))
+	router.GET("/fast", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "done"})
+	})
+	// a slow query honouring the deadline, like the MongoDB driver does
+	router.GET("/cancelled", func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+			c.Abort()
+		case <-time.After(time.Second):
+			c.JSON(http.StatusOK, gin.H{"status": "done"})
+		}
+	})
+	// a handler ignoring the deadline, like a go-redis v6 call, isn't cut
+	// off: it runs to the end and only its answer is dropped
+	lateFinished := false
+	router.GET("/late", func(c *gin.Context) {
+		time.Sleep(100 * time.Millisecond)
+		lateFinished = true
+		c.Header("X-Late", "true")
+		c.JSON(http.StatusOK, gin.H{"status": "late"})
+	})
+
+	if w := serve(router, http.MethodGet, "/fast", ""); w.Code != http.StatusOK {
+		t.Errorf("fast: got %d, want 200", w.Code)
+	}
+	for _, path := range []string{"/cancelled", "/late"} {
+		w := serve(router, http.MethodGet, path, "")
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: got %d, want 503", path, w.Code)
+		}
+		var body struct {
+			Error APIError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != "timeout" {
+			t.Errorf("%s: body %s, want the timeout envelope", path, w.Body)
+		}
+		if strings.Contains(w.Body.String(), "late") || w.Header().Get("X-Late") != "" {
+			t.Errorf("%s: the late response got through: %v %s", path, w.Header(), w.Body)
+		}
+	}
+	if !lateFinished {
+		t.Error("late: answered before the handler returned")
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Jovdza012/gin_chapter_2/handlers")
+
+// TraceAttributes adds the request id, the username and the recipe id to the
+// span of the request. It must run inside the tracing middleware; the
+// username is set by the auth middlewares, so the attributes are only added
+// once the request has been handled.
+func TraceAttributes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		span := trace.SpanFromContext(c.Request.Context())
+		if !span.IsRecording() {
+			return
+		}
+		span.SetAttributes(attribute.String("request.id", GetRequestID(c)))
+		if user, ok := CurrentUser(c); ok && user.Username != "" {
+			span.SetAttributes(attribute.String("enduser.id", user.Username))
+		}
+		if strings.Contains(c.FullPath(), "/recipes/:id") {
+			span.SetAttributes(attribute.String("recipe.id", c.Param("id")))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+)
+
+var (
+	transactionsMu        sync.Mutex
+	transactionsDetected  bool
+	transactionsSupported bool
+)
+
+// supportsTransactions reports whether the server is a replica set member or
+// a mongos; standalone servers reject transactions. A successful detection is
+// cached, the topology doesn't change while the process runs. A failed one
+// answers false and is tried again on the next call, so a server that was
+// briefly unreachable doesn't lose its transactions for good.
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	transactionsMu.Lock()
+	defer transactionsMu.Unlock()
+	if transactionsDetected {
+		return transactionsSupported
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to detect MongoDB topology", "error", err)
+		return false
+	}
+	transactionsSupported = hello.SetName != "" || hello.Msg == "isdbgrid"
+	transactionsDetected = true
+	return transactionsSupported
+}
+
+// withTransaction runs fn in a transaction, retrying on transient errors as
+// the driver does. On a standalone server fn runs without a transaction, so
+// its writes are not atomic, and a warning is logged.
+func withTransaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	if !supportsTransactions(ctx, client) {
+		loggerFrom(ctx).Warn("MongoDB does not support transactions, running without one")
+		return fn(ctx)
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
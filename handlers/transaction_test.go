@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestSupportsTransactionsRetriesAfterFailure(t *testing.T) {
+	transactionsDetected, transactionsSupported = false, false
+	t.Cleanup(func() { transactionsDetected, transactionsSupported = false, false })
+
+	down, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://"+closedAddr(t)).SetServerSelectionTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Disconnect(context.Background())
+	if supportsTransactions(context.Background(), down) || transactionsDetected {
+		t.Fatal("a failed detection was cached")
+	}
+
+	db := testDatabase(t)
+	supported := supportsTransactions(context.Background(), db.Client())
+	if !transactionsDetected {
+		t.Fatal("detection wasn't retried once MongoDB answered")
+	}
+	if supportsTransactions(context.Background(), down) != supported {
+		t.Error("a successful detection wasn't cached")
+	}
+}
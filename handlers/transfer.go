@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+// swagger:operation POST /recipes/{id}/transfer recipes transferRecipe
+// Transfer a recipe to another user of the organization
+//
+// The new owner and the audit record are written in a single transaction.
+// ---
+// produces:
+// - application/json
+// - application/xml
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the recipe
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     description: New owner
+//     required: true
+//     schema: {type: object, required: [owner], properties: {owner: {type: string}}}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid input or unknown user
+//	'403':
+//	    description: Not the owner of the recipe
+//	'404':
+//	    description: Recipe not found
+func (handler *RecipesHandler) TransferRecipeHandler(c *gin.Context) {
+	id := c.Param("id")
+	objectId, ok := parseObjectID(c, id)
+	if !ok {
+		return
+	}
+	var body struct {
+		Owner string `json:"owner" binding:"required"`
+	}
+	if !bindJSON(c, &body) {
+		return
+	}
+	if !handler.authorizeOwner(c, objectId) {
+		return
+	}
+
+	db := handler.collection.Database()
+	count, err := db.Collection("users").CountDocuments(c.Request.Context(), inOrg(c, bson.M{"username": body.Owner}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if count == 0 {
+		respondError(c, http.StatusBadRequest, "bad_request", "Unknown user "+body.Owner)
+		return
+	}
+
+	err = withTransaction(c.Request.Context(), db.Client(), func(ctx context.Context) error {
+		var previous struct {
+			Owner string `bson:"owner"`
+		}
+		err := handler.collection.FindOneAndUpdate(ctx, inOrg(c, bson.M{
+			"_id":       objectId,
+			"deletedAt": notDeleted,
+		}), bson.M{"$set": bson.M{"owner": body.Owner, "updatedAt": time.Now()}}).Decode(&previous)
+		if err != nil {
+			return err
+		}
+
+		user, _ := CurrentUser(c)
+		_, err = db.Collection("audit").InsertOne(ctx, models.AuditEntry{
+			Actor:     user.Username,
+			Action:    "transfer",
+			RecipeID:  objectId,
+			OrgID:     orgOf(c),
+			Timestamp: time.Now(),
+			Snapshot:  map[string]interface{}{"from": previous.Owner, "to": body.Owner},
+		})
+		return err
+	})
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, "not_found", "Recipe not found")
+		return
+	}
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	handler.invalidateCache(id)
+	handler.notify(c, "transfer", objectId, map[string]interface{}{"owner": body.Owner})
+
+	handler.respond(c, http.StatusOK, gin.H{"message": "Recipe has been transferred", "owner": body.Owner})
+}
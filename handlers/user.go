@@ -0,0 +1,30 @@
+package handlers
+
+import "github.com/gin-gonic/gin"
+
+const userKey = "user"
+
+// AuthUser is the caller of an authenticated request. ID is the id of the
+// account, empty for API keys and for sessions and tokens issued before it
+// was recorded in them. OrgID is the organization the request acts in, see
+// orgOf.
+type AuthUser struct {
+	ID       string
+	Username string
+	Roles    []string
+	OrgID    string
+}
+
+// setCurrentUser is called by the auth middlewares once they resolved the
+// caller, so handlers never read the session or the token themselves.
+func setCurrentUser(c *gin.Context, user AuthUser) {
+	c.Set(userKey, user)
+}
+
+// CurrentUser returns the caller of the request. ok is false, along with a
+// zero AuthUser, on routes that don't authenticate.
+func CurrentUser(c *gin.Context) (AuthUser, bool) {
+	value, _ := c.Get(userKey)
+	user, ok := value.(AuthUser)
+	return user, ok
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRecipeValidation(t *testing.T) {
+	// validation fails before the database is reached
+	router := recipesRouter(&RecipesHandler{}, AuthUser{Username: "cook"})
+	tests := []struct {
+		name   string
+		body   string
+		fields map[string]string
+	}{
+		{
+			name:   "missing name",
+			body:   `{"ingredients": ["flour"], "instructions": ["mix"]}`,
+			fields: map[string]string{"name": "is required"},
+		},
+		{
+			name:   "empty ingredients",
+			body:   `{"name": "Bread", "ingredients": [], "instructions": ["mix"]}`,
+			fields: map[string]string{"ingredients": "must contain at least 1 item(s)"},
+		},
+		{
+			name:   "empty body",
+			body:   `{}`,
+			fields: map[string]string{"name": "is required", "ingredients": "is required", "instructions": "is required"},
+		},
+	}
+	paths := map[string]string{
+		http.MethodPost: "/recipes",
+		http.MethodPut:  "/recipes/" + primitive.NewObjectID().Hex(),
+	}
+
+	for _, test := range tests {
+		for method, path := range paths {
+			w := serve(router, method, path, test.body)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: got %d, want 400", method, test.name, w.Code)
+				continue
+			}
+			var body struct {
+				Error APIError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != "validation_failed" {
+				t.Errorf("%s %s: code %q, want validation_failed", method, test.name, body.Error.Code)
+			}
+			got := map[string]string{}
+			for _, field := range body.Error.Fields {
+				got[field.Field] = field.Message
+			}
+			if len(got) != len(test.fields) {
+				t.Errorf("%s %s: fields %v, want %v", method, test.name, got, test.fields)
+			}
+			for field, message := range test.fields {
+				if got[field] != message {
+					t.Errorf("%s %s: %s %q, want %q", method, test.name, field, got[field], message)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+)
+
+// pendingViews is the Redis hash counting the views of each recipe since the
+// last flush to MongoDB
+const pendingViews = "views:pending"
+
+// countView adds a view of the recipe to the pending counts. It runs in the
+// background so the read never waits on it, a lost view is not worth an error.
+func (handler *RecipesHandler) countView(c *gin.Context, id string) {
+	logger := loggerFrom(c.Request.Context())
+	go func() {
+		if err := handler.redisClient.HIncrBy(pendingViews, id, 1).Err(); err != nil {
+			logger.Warn("Failed to count view", "recipeId", id, "error", err)
+		}
+	}()
+}
+
+// FlushViews adds the pending view counts to the recipes every interval,
+// until ctx is cancelled, and one last time then.
+func (handler *RecipesHandler) FlushViews(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			handler.flushViews(context.Background())
+			return
+		case <-ticker.C:
+			handler.flushViews(ctx)
+		}
+	}
+}
+
+// flushViews moves the pending counts aside, so views counted meanwhile go to
+// the next flush and two instances never flush the same views, and adds them
+// to the recipes. Counts that fail to reach MongoDB are put back.
+func (handler *RecipesHandler) flushViews(ctx context.Context) {
+	// no views since the last flush
+	if handler.redisClient.Exists(pendingViews).Val() == 0 {
+		return
+	}
+	flushing := "views:flushing:" + xid.New().String()
+	if err := handler.redisClient.Rename(pendingViews, flushing).Err(); err != nil {
+		slog.Warn("Failed to flush views", "error", err)
+		return
+	}
+	counts, err := handler.redisClient.HGetAll(flushing).Result()
+	if err != nil {
+		slog.Warn("Failed to flush views", "error", err)
+		return
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(counts))
+	for id, value := range counts {
+		objectId, err := primitive.ObjectIDFromHex(id)
+		views, _ := strconv.ParseInt(value, 10, 64)
+		if err != nil || views <= 0 {
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": objectId}).
+			SetUpdate(bson.M{"$inc": bson.M{"views": views}}))
+	}
+	if len(writes) > 0 {
+		if _, err := handler.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			slog.Warn("Failed to flush views, keeping them for the next flush", "error", err)
+			pipe := handler.redisClient.TxPipeline()
+			for id, value := range counts {
+				views, _ := strconv.ParseInt(value, 10, 64)
+				pipe.HIncrBy(pendingViews, id, views)
+			}
+			pipe.Del(flushing)
+			if _, err := pipe.Exec(); err != nil {
+				slog.Error("Failed to keep views for the next flush", "error", err)
+			}
+			return
+		}
+	}
+	handler.redisClient.Del(flushing)
+}
+
+// swagger:operation GET /recipes/popular recipes popularRecipes
+// Returns a page of the most viewed recipes
+//
+// Views are counted in Redis and added to the recipes every
+// VIEWS_FLUSH_INTERVAL, so the latest ones are not counted yet.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: page
+//     in: query
+//     description: page number, starting at 1
+//     required: false
+//     type: integer
+//   - name: limit
+//     in: query
+//     description: number of recipes per page, clamped to MAX_PAGE_LIMIT (100 by default)
+//     required: false
+//     type: integer
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'400':
+//	    description: Invalid query parameters
+func (handler *RecipesHandler) PopularRecipesHandler(c *gin.Context) {
+	page, limit, err := parsePagination(c, handler.maxLimit)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	sort := bson.D{{Key: "views", Value: -1}, {Key: "_id", Value: 1}}
+	list, err := findPage(c.Request.Context(), handler.collection, inOrg(c, bson.M{"deletedAt": notDeleted}), options.Find().SetSort(sort), page, limit)
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	setPaginationLinks(c, list)
+	c.JSON(http.StatusOK, list)
+}
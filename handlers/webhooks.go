@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookMaxAttempts     = 5
+	// webhookBackoff is the wait before the first retry, doubled after every
+	// failed attempt
+	webhookBackoff = time.Second
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type WebhooksHandler struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+func NewWebhooksHandler(ctx context.Context, collection *mongo.Collection) *WebhooksHandler {
+	return &WebhooksHandler{
+		collection: collection,
+		ctx:        ctx,
+	}
+}
+
+// swagger:operation POST /webhooks webhooks newWebhook
+// Register a webhook notified of writes on the recipes of the organization, admins only
+//
+// Every delivery is a POST of the event signed with HMAC-SHA256: the
+// X-Webhook-Signature header holds sha256= followed by the hex digest of the
+// body, keyed with the secret returned here. The secret is not shown again.
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: body
+//     in: body
+//     description: Webhook to register
+//     required: true
+//     schema: {$ref: '#/definitions/Webhook'}
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'201':
+//	    description: The webhook and its secret
+//	'400':
+//	    description: Invalid URL or events
+//	'403':
+//	    description: Not an admin
+func (handler *WebhooksHandler) NewWebhookHandler(c *gin.Context) {
+	var webhook models.Webhook
+	if !bindJSON(c, &webhook) {
+		return
+	}
+	if parsed, err := url.Parse(webhook.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		respondValidationError(c, []FieldError{{Field: "url", Message: "must be an http or https URL"}})
+		return
+	}
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	webhook.ID = primitive.NewObjectID()
+	webhook.Secret = secret
+	user, _ := CurrentUser(c)
+	webhook.OrgID = orgOf(c)
+	webhook.CreatedBy = user.Username
+	webhook.CreatedAt = time.Now()
+	if _, err := handler.collection.InsertOne(c.Request.Context(), webhook); err != nil {
+		respondDBError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"secret": secret, "webhook": webhook})
+}
+
+// swagger:operation GET /webhooks webhooks listWebhooks
+// List the webhooks of the organization, admins only
+// ---
+// produces:
+// - application/json
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Successful operation
+//	'403':
+//	    description: Not an admin
+func (handler *WebhooksHandler) ListWebhooksHandler(c *gin.Context) {
+	cur, err := handler.collection.Find(c.Request.Context(), inOrg(c, bson.M{}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	defer cur.Close(c.Request.Context())
+
+	webhooks := make([]models.Webhook, 0)
+	if err := cur.All(c.Request.Context(), &webhooks); err != nil {
+		respondDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// swagger:operation DELETE /webhooks/{id} webhooks deleteWebhook
+// Delete a webhook, admins only
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: id
+//     in: path
+//     description: ID of the webhook
+//     required: true
+//     type: string
+//
+// security:
+//   - session: []
+//   - bearer: []
+//   - apiKey: []
+//
+// responses:
+//
+//	'200':
+//	    description: Webhook deleted
+//	'400':
+//	    description: Invalid webhook ID
+//	'403':
+//	    description: Not an admin
+//	'404':
+//	    description: Webhook not found
+func (handler *WebhooksHandler) DeleteWebhookHandler(c *gin.Context) {
+	objectId, ok := parseObjectID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	result, err := handler.collection.DeleteOne(c.Request.Context(), inOrg(c, bson.M{"_id": objectId}))
+	if err != nil {
+		respondDBError(c, err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondError(c, http.StatusNotFound, "not_found", "Webhook not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook has been deleted"})
+}
+
+// notify publishes a write on a recipe to the event streams and delivers it
+// to the webhooks of its organization subscribed to action. Like audit it runs in the background:
+// deliveries are retried with backoff and, after webhookMaxAttempts, recorded
+// in the webhook_deadletters collection.
+func (handler *RecipesHandler) notify(c *gin.Context, action string, recipeId primitive.ObjectID, snapshot map[string]interface{}) {
+	user, _ := CurrentUser(c)
+	event := models.WebhookEvent{
+		ID:        xid.New().String(),
+		Action:    action,
+		RecipeID:  recipeId,
+		OrgID:     orgOf(c),
+		Actor:     user.Username,
+		Timestamp: time.Now(),
+		Recipe:    snapshot,
+	}
+	db := handler.collection.Database()
+	logger := loggerFrom(c.Request.Context())
+	go func() {
+		body, err := json.Marshal(event)
+		if err != nil {
+			logger.Error("Failed to encode webhook event", "error", err)
+			return
+		}
+		if err := handler.publish(body); err != nil {
+			logger.Error("Failed to publish recipe event", "error", err)
+		}
+		cur, err := db.Collection("webhooks").Find(handler.ctx, bson.M{"orgId": event.OrgID, "$or": bson.A{
+			bson.M{"events": bson.M{"$size": 0}},
+			bson.M{"events": action},
+		}})
+		if err != nil {
+			logger.Error("Failed to load webhooks", "error", err)
+			return
+		}
+		var webhooks []models.Webhook
+		if err := cur.All(handler.ctx, &webhooks); err != nil {
+			logger.Error("Failed to load webhooks", "error", err)
+			return
+		}
+
+		for _, webhook := range webhooks {
+			go func(webhook models.Webhook) {
+				attempts, err := deliverWebhook(handler.ctx, webhook, event, body)
+				if err == nil {
+					return
+				}
+				logger.Error("Webhook delivery failed", "webhook", webhook.ID.Hex(), "event", event.ID, "attempts", attempts, "error", err)
+				_, err = db.Collection("webhook_deadletters").InsertOne(handler.ctx, bson.M{
+					"webhookId": webhook.ID,
+					"url":       webhook.URL,
+					"event":     event,
+					"attempts":  attempts,
+					"error":     err.Error(),
+					"failedAt":  time.Now(),
+				})
+				if err != nil {
+					logger.Error("Failed to record webhook dead letter", "error", err)
+				}
+			}(webhook)
+		}
+	}()
+}
+
+// deliverWebhook POSTs body to the webhook until it answers with a 2xx,
+// returning the number of attempts made and the last error.
+func deliverWebhook(ctx context.Context, webhook models.Webhook, event models.WebhookEvent, body []byte) (int, error) {
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	backoff := webhookBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(ctx, webhook.URL, event, signature, body)
+		if err == nil || attempt == webhookMaxAttempts {
+			return attempt, err
+		}
+		if !sleep(ctx, backoff) {
+			return attempt, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func postWebhook(ctx context.Context, url string, event models.WebhookEvent, signature string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Action)
+	req.Header.Set("X-Webhook-Id", event.ID)
+	req.Header.Set(webhookSignatureHeader, signature)
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
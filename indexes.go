@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes lists, per collection, the indexes the API relies on. Each one has an
+// explicit name so restarts recognise it as already present.
+var indexes = map[string][]mongo.IndexModel{
+	"users": {
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName("username_unique").SetUnique(true),
+		},
+	},
+	"apikeys": {
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_unique").SetUnique(true),
+		},
+	},
+	"favorites": {
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}, {Key: "recipeId", Value: 1}},
+			Options: options.Index().SetName("username_recipe_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "recipeId", Value: 1}},
+			Options: options.Index().SetName("recipeId"),
+		},
+	},
+	"ratings": {
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}, {Key: "recipeId", Value: 1}},
+			Options: options.Index().SetName("username_recipe_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "recipeId", Value: 1}},
+			Options: options.Index().SetName("recipeId"),
+		},
+	},
+	"tombstones": {
+		{
+			Keys:    bson.D{{Key: "deletedAt", Value: 1}},
+			Options: options.Index().SetName("deletedAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "deletedAt", Value: 1}},
+			Options: options.Index().SetName("orgId_deletedAt"),
+		},
+	},
+	"comments": {
+		{
+			Keys:    bson.D{{Key: "recipeId", Value: 1}, {Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName("recipeId_createdAt"),
+		},
+	},
+	"recipes": {
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "createdAt", Value: -1}},
+			Options: options.Index().SetName("orgId_createdAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "ingredients", Value: "text"}},
+			Options: options.Index().SetName("name_ingredients_text"),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetName("name"),
+		},
+		{
+			Keys:    bson.D{{Key: "tags", Value: 1}},
+			Options: options.Index().SetName("tags"),
+		},
+		{
+			Keys:    bson.D{{Key: "cuisine", Value: 1}},
+			Options: options.Index().SetName("cuisine"),
+		},
+		{
+			Keys:    bson.D{{Key: "avgRating", Value: -1}},
+			Options: options.Index().SetName("avgRating"),
+		},
+		{
+			Keys:    bson.D{{Key: "views", Value: -1}},
+			Options: options.Index().SetName("views"),
+		},
+		{
+			Keys:    bson.D{{Key: "updatedAt", Value: 1}},
+			Options: options.Index().SetName("updatedAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "createdAt", Value: -1}},
+			Options: options.Index().SetName("owner_createdAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "deletedAt", Value: 1}},
+			Options: options.Index().SetName("deletedAt").SetSparse(true),
+		},
+	},
+}
+
+// ensureIndexes creates any missing index. CreateMany is a no-op for indexes
+// that already exist with the same definition, so it is safe on every start.
+func ensureIndexes(ctx context.Context, db *mongo.Database) error {
+	for collectionName, models := range indexes {
+		collection := db.Collection(collectionName)
+
+		existing, err := indexNames(ctx, collection)
+		if err != nil {
+			return err
+		}
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+
+		for _, model := range models {
+			name := *model.Options.Name
+			if existing[name] {
+				slog.Debug("Index already present", "collection", collectionName, "index", name)
+			} else {
+				slog.Info("Index created", "collection", collectionName, "index", name)
+			}
+		}
+	}
+	return nil
+}
+
+// dropIndexes drops the indexes listed in indexes, leaving any other index
+// alone.
+func dropIndexes(ctx context.Context, db *mongo.Database) error {
+	for collectionName, models := range indexes {
+		collection := db.Collection(collectionName)
+
+		existing, err := indexNames(ctx, collection)
+		if err != nil {
+			return err
+		}
+		for _, model := range models {
+			name := *model.Options.Name
+			if !existing[name] {
+				continue
+			}
+			if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+				return err
+			}
+			slog.Info("Index dropped", "collection", collectionName, "index", name)
+		}
+	}
+	return nil
+}
+
+func indexNames(ctx context.Context, collection *mongo.Collection) (map[string]bool, error) {
+	cur, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	names := make(map[string]bool)
+	for cur.Next(ctx) {
+		var index struct {
+			Name string `bson:"name"`
+		}
+		if err := cur.Decode(&index); err != nil {
+			return nil, err
+		}
+		names[index.Name] = true
+	}
+	return names, cur.Err()
+}
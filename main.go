@@ -2,6 +2,13 @@
 //
 // This is a sample recipes API. You can find out more about the API at https://github.com/PacktPublishing/Building-Distributed-Applications-in-Gin.
 //
+// Operations that can answer 429 are rate limited. Every answer to them,
+// successful or not, carries the state of the caller's limit:
+// X-RateLimit-Limit, the requests allowed per window, X-RateLimit-Remaining,
+// the requests that can still be made right away, and X-RateLimit-Reset, the
+// seconds until all of them can again. A 429 adds Retry-After, the seconds
+// until the next request is allowed.
+//
 //		Schemes: http
 //	 Host: localhost:8080
 //		BasePath: /
@@ -14,82 +21,102 @@
 //		Produces:
 //		- application/json
 //
+//		SecurityDefinitions:
+//		session:
+//		     type: apiKey
+//		     in: header
+//		     name: Cookie
+//		     description: recipes_api session cookie set by POST /signin when AUTH_MODE=session
+//		bearer:
+//		     type: apiKey
+//		     in: header
+//		     name: Authorization
+//		     description: '"Bearer <token>" as returned by POST /signin when AUTH_MODE=jwt'
+//		apiKey:
+//		     type: apiKey
+//		     in: header
+//		     name: X-API-Key
+//		     description: key created with POST /apikeys, for service-to-service calls
+//
 // swagger:meta
 package main
 
 import (
 	"context"
-	"log"
+	_ "embed"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 
-	"github.com/gin-contrib/sessions"
-	redisStore "github.com/gin-contrib/sessions/redis"
-	"github.com/gin-gonic/gin"
-	redis "github.com/go-redis/redis"
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
-
-	handlers "github.com/Jovdza012/gin_chapter_2/handlers"
 )
 
-var authHandler *handlers.AuthHandler
-var recipesHandler *handlers.RecipesHandler
+//go:embed swagger.json
+var swaggerSpec []byte
 
-func init() {
+func main() {
+	migrateOnly := flag.Bool("migrate", false, "run the pending migrations and exit, same as the migrate command")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-migrate] [command]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), commandsUsage())
+	}
+	flag.Parse()
 
 	// Environment variables retrive
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found. Using system environment variables.")
+		slog.Info("No .env file found. Using system environment variables.")
 	}
 
-	if os.Getenv("MONGO_URI") == "" || os.Getenv("MONGO_DATABASE") == "" {
-		log.Fatal("Environment variables MONGO_URI or MONGO_DATABASE are not set")
+	config, err := LoadConfig()
+	if err != nil {
+		// printed as is, a log line would squash the list of problems
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	logger := newLogger(config)
+	slog.SetDefault(logger)
+	shutdownTracing, err := setupTracing(context.Background(), config)
+	if err != nil {
+		fatal(err)
 	}
 
-	// MongoDb connection
-	ctx := context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_URI")))
-	if err = client.Ping(context.TODO(), readpref.Primary()); err != nil {
-		log.Fatal(err)
+	// a command runs an admin task instead of serving the API
+	args := flag.Args()
+	if *migrateOnly {
+		args = []string{"migrate"}
+	}
+	if len(args) > 0 {
+		if err := runCommand(config, logger, args[0], args[1:]); err != nil {
+			fatal(err)
+		}
+		return
 	}
-	log.Println("Connected to MongoDB")
-	collection := client.Database(os.Getenv("MONGO_DATABASE")).Collection("recipes")
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
-	status, err := redisClient.Ping().Result()
+	app, err := NewApp(config, logger)
 	if err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+		fatal(err)
+	}
+	if err := app.Run(); err != nil {
+		fatal(err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Warn("Failed to flush traces", "error", err)
 	}
-	log.Println("Connected to Redis:", status)
-
-	// Hanlder initializetion
-	recipesHandler = handlers.NewRecipesHandler(ctx, collection, redisClient)
-	collectionUsers := client.Database(os.Getenv("MONGO_DATABASE")).Collection("users")
-	authHandler = handlers.NewAuthHandler(ctx, collectionUsers)
-
 }
 
-func main() {
-	router := gin.Default()
-	store, _ := redisStore.NewStore(10, "tcp", "localhost:6379", "", []byte("secret"))
-	router.Use(sessions.Sessions("recipes_api", store))
-	authorized := router.Group("/")
-	authorized.Use(authHandler.AuthMiddleware())
-	{
-		authorized.POST("/recipes", recipesHandler.NewRecipeHandler)
-		authorized.GET("/recipes", recipesHandler.ListRecipesHandler)
-		authorized.PUT("/recipes/:id", recipesHandler.UpdateRecipeHandler)
-		authorized.DELETE("/recipes/:id", recipesHandler.DeleteRecipeHandler)
-		authorized.GET("/recipes/:id", recipesHandler.GetOneRecipeHandler)
+// newLogger logs at LOG_LEVEL to stderr, as JSON lines when LOG_FORMAT=json.
+func newLogger(config Config) *slog.Logger {
+	options := &slog.HandlerOptions{Level: config.LogLevel}
+	if config.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, options))
 	}
-	router.POST("/signin", authHandler.SignInHandler)
-	router.POST("/signout", authHandler.SignOutHandler)
+	return slog.New(slog.NewTextHandler(os.Stderr, options))
+}
 
-	router.Run()
+// fatal logs a startup failure and exits.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	redis "github.com/go-redis/redis"
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	handlers "github.com/Jovdza012/gin_chapter_2/handlers"
+	"github.com/Jovdza012/gin_chapter_2/models"
+)
+
+const (
+	migrationLockKey = "migrations:lock"
+	// migrationLockTTL bounds how long one instance may hold the lock, and so
+	// how long each migration may run, should the instance die midway.
+	migrationLockTTL      = 10 * time.Minute
+	migrationLockInterval = time.Second
+)
+
+// migration is one change to the stored data. up must be safe to run again
+// after a failure, since a migration only counts as applied once it succeeds.
+type migration struct {
+	id string
+	up func(ctx context.Context, db *mongo.Database) error
+}
+
+// migrations lists every migration in the order they run. Ids are recorded
+// in the migrations collection once applied, so never rename or reorder
+// them; add new ones at the end.
+var migrations = []migration{
+	{id: "0001_recipe_timestamps", up: backfillRecipeTimestamps},
+	{id: "0002_seed_nutrition", up: seedNutrition},
+	{id: "0003_default_org", up: backfillDefaultOrg},
+}
+
+//go:embed nutrition.json
+var nutritionSeed []byte
+
+// releaseLockScript deletes the lock only if this instance still holds it,
+// so an instance whose lock expired can't release another instance's lock.
+var releaseLockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// migrate runs the pending migrations. A lock in Redis makes instances that
+// start together take turns, the ones waiting find the migrations applied
+// once they get the lock.
+func migrate(ctx context.Context, db *mongo.Database, redisClient *redis.Client) error {
+	token := xid.New().String()
+	for {
+		acquired, err := redisClient.SetNX(migrationLockKey, token, migrationLockTTL).Result()
+		if err != nil {
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+		if acquired {
+			break
+		}
+		slog.Info("Waiting for another instance to finish migrating")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockInterval):
+		}
+	}
+	defer func() {
+		if err := releaseLockScript.Run(redisClient, []string{migrationLockKey}, token).Err(); err != nil {
+			slog.Warn("Failed to release the migration lock", "error", err)
+		}
+	}()
+
+	collection := db.Collection("migrations")
+	for _, m := range migrations {
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": m.id})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		slog.Info("Applying migration", "id", m.id)
+		migrationCtx, cancel := context.WithTimeout(ctx, migrationLockTTL)
+		err = m.up(migrationCtx, db)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.id, err)
+		}
+		if _, err := collection.InsertOne(ctx, bson.M{"_id": m.id, "appliedAt": time.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillRecipeTimestamps gives recipes stored before createdAt and
+// updatedAt existed both timestamps, taken from publishedAt or else from the
+// creation time encoded in the ObjectID.
+func backfillRecipeTimestamps(ctx context.Context, db *mongo.Database) error {
+	result, err := db.Collection("recipes").UpdateMany(ctx,
+		bson.M{"createdAt": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"createdAt": bson.M{"$ifNull": bson.A{"$publishedAt", bson.M{"$toDate": "$_id"}}},
+			}}},
+			{{Key: "$set", Value: bson.M{
+				"updatedAt": bson.M{"$ifNull": bson.A{"$updatedAt", "$createdAt"}},
+			}}},
+		})
+	if err != nil {
+		return err
+	}
+	slog.Info("Backfilled recipe timestamps", "recipes", result.ModifiedCount)
+	return nil
+}
+
+// seedNutrition fills the nutrition table the recipe nutrition facts are
+// computed from. Entries already in the table are left as they are, so
+// corrections made to it survive.
+func seedNutrition(ctx context.Context, db *mongo.Database) error {
+	var entries []models.NutritionEntry
+	if err := json.Unmarshal(nutritionSeed, &entries); err != nil {
+		return err
+	}
+	writes := make([]mongo.WriteModel, len(entries))
+	for i, entry := range entries {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": entry.Name}).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{
+				"calories":    entry.Calories,
+				"protein":     entry.Protein,
+				"carbs":       entry.Carbs,
+				"fat":         entry.Fat,
+				"density":     entry.Density,
+				"pieceWeight": entry.PieceWeight,
+			}}).
+			SetUpsert(true)
+	}
+	result, err := db.Collection("nutrition").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	slog.Info("Seeded the nutrition table", "entries", result.UpsertedCount)
+	return nil
+}
+
+// orgCollections are the collections whose documents belong to an
+// organization.
+var orgCollections = []string{"users", "recipes", "apikeys", "webhooks", "audit", "tombstones"}
+
+// backfillDefaultOrg puts the documents stored before organizations existed
+// in the default one.
+func backfillDefaultOrg(ctx context.Context, db *mongo.Database) error {
+	for _, name := range orgCollections {
+		result, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{"orgId": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"orgId": handlers.DefaultOrg}})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		slog.Info("Moved documents to the default organization", "collection", name, "documents", result.ModifiedCount)
+	}
+	return nil
+}
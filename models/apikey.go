@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey lets a backend service call the API without a user account. Only the
+// SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name" binding:"required"`
+	Hash      string             `json:"-" bson:"hash"`
+	Prefix    string             `json:"prefix" bson:"prefix"`
+	Scopes    []string           `json:"scopes" bson:"scopes" binding:"dive,oneof=read write admin"`
+	RateLimit int64              `json:"rateLimit,omitempty" bson:"rateLimit,omitempty" binding:"min=0"`
+	OrgID     string             `json:"orgId" bson:"orgId"`
+	CreatedBy string             `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	RevokedAt *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEntry records a write made through the API.
+type AuditEntry struct {
+	ID        primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Actor     string                 `json:"actor" bson:"actor"`
+	Action    string                 `json:"action" bson:"action"`
+	RecipeID  primitive.ObjectID     `json:"recipeId" bson:"recipeId"`
+	OrgID     string                 `json:"orgId" bson:"orgId"`
+	Timestamp time.Time              `json:"timestamp" bson:"timestamp"`
+	Snapshot  map[string]interface{} `json:"snapshot,omitempty" bson:"snapshot,omitempty"`
+}
+
+// AuditList is a single page of audit entries along with the paging details.
+type AuditList struct {
+	Data       []AuditEntry `json:"data"`
+	Page       int64        `json:"page"`
+	Limit      int64        `json:"limit"`
+	Total      int64        `json:"total"`
+	TotalPages int64        `json:"totalPages"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Comment is a message left by a user on a recipe.
+type Comment struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	RecipeID  primitive.ObjectID `json:"recipeId" bson:"recipeId"`
+	Author    string             `json:"author" bson:"author"`
+	Body      string             `json:"body" bson:"body" binding:"required,max=2000"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// CommentList is a single page of comments along with the paging details.
+type CommentList struct {
+	Data       []Comment `json:"data"`
+	Page       int64     `json:"page"`
+	Limit      int64     `json:"limit"`
+	Total      int64     `json:"total"`
+	TotalPages int64     `json:"totalPages"`
+}
@@ -0,0 +1,30 @@
+package models
+
+// NutritionFacts are the energy in kcal and the macronutrients in grams.
+type NutritionFacts struct {
+	Calories float64 `json:"calories" bson:"calories"`
+	Protein  float64 `json:"protein" bson:"protein"`
+	Carbs    float64 `json:"carbs" bson:"carbs"`
+	Fat      float64 `json:"fat" bson:"fat"`
+}
+
+// NutritionEntry is a row of the nutrition table, keyed by the singular name
+// of the ingredient. The facts are per 100 g. Density, in grams per
+// milliliter, converts volumes and is taken as 1 when unset; PieceWeight is
+// the weight in grams of one piece, such as one egg, for ingredients counted
+// without a unit.
+type NutritionEntry struct {
+	Name           string `json:"name" bson:"_id"`
+	NutritionFacts `bson:",inline"`
+	Density        float64 `json:"density,omitempty" bson:"density,omitempty"`
+	PieceWeight    float64 `json:"pieceWeight,omitempty" bson:"pieceWeight,omitempty"`
+}
+
+// Nutrition sums up the nutrition facts of a recipe. Unknown lists the
+// ingredients left out of the totals, because they are not in the nutrition
+// table or have no quantity that can be weighed.
+type Nutrition struct {
+	Total      NutritionFacts  `json:"total"`
+	PerServing *NutritionFacts `json:"perServing,omitempty"`
+	Unknown    []string        `json:"unknown"`
+}
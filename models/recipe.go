@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/xml"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -8,11 +9,108 @@ import (
 
 // swagger:parameters recipes newRecipe
 type Recipe struct {
+	XMLName xml.Name `json:"-" xml:"recipe" bson:"-"`
 	//swagger:ignore
-	ID           primitive.ObjectID `json:"id" bson:"_id"`
-	Name         string             `json:"name" bson:"name"`
-	Tags         []string           `json:"tags" bson:"tags"`
-	Ingredients  []string           `json:"ingredients" bson:"ingredients"`
-	Instructions []string           `json:"instructions" bson:"instructions"`
-	PublishedAt  time.Time          `json:"publishedAt" bson:"publishedAt"`
+	ID           primitive.ObjectID `json:"id" xml:"id" bson:"_id"`
+	Name         string             `json:"name" xml:"name" bson:"name" binding:"required"`
+	Tags         []string           `json:"tags" xml:"tags>tag" bson:"tags"`
+	Cuisine      string             `json:"cuisine,omitempty" xml:"cuisine,omitempty" bson:"cuisine,omitempty"`
+	Ingredients  []string           `json:"ingredients" xml:"ingredients>ingredient" bson:"ingredients" binding:"required,min=1"`
+	Servings     int                `json:"servings,omitempty" xml:"servings,omitempty" bson:"servings,omitempty" binding:"omitempty,min=1,max=1000"`
+	Instructions []string           `json:"instructions" xml:"instructions>instruction" bson:"instructions" binding:"required,min=1"`
+	Difficulty   string             `json:"difficulty,omitempty" xml:"difficulty,omitempty" bson:"difficulty,omitempty" binding:"omitempty,oneof=easy medium hard"`
+	PrepTime     int                `json:"prepTime,omitempty" xml:"prepTime,omitempty" bson:"prepTime,omitempty" binding:"omitempty,min=0"`
+	CookTime     int                `json:"cookTime,omitempty" xml:"cookTime,omitempty" bson:"cookTime,omitempty" binding:"omitempty,min=0"`
+	PublishedAt  time.Time          `json:"publishedAt" xml:"publishedAt" bson:"publishedAt"`
+	CreatedAt    time.Time          `json:"createdAt" xml:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time          `json:"updatedAt" xml:"updatedAt" bson:"updatedAt"`
+	Owner        string             `json:"owner" xml:"owner" bson:"owner"`
+	OrgID        string             `json:"orgId,omitempty" xml:"orgId,omitempty" bson:"orgId,omitempty"`
+	DeletedAt    *time.Time         `json:"deletedAt,omitempty" xml:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	Image        *Image             `json:"image,omitempty" xml:"image,omitempty" bson:"image,omitempty"`
+	Images       []Image            `json:"images,omitempty" xml:"images>image,omitempty" bson:"images,omitempty"`
+	Version      *int64             `json:"version,omitempty" xml:"version,omitempty" bson:"version,omitempty"`
+	AvgRating    float64            `json:"avgRating" xml:"avgRating" bson:"avgRating,omitempty"`
+	RatingCount  int64              `json:"ratingCount" xml:"ratingCount" bson:"ratingCount,omitempty"`
+	Views        int64              `json:"views" xml:"views" bson:"views,omitempty"`
+	// Score is the text search relevance, only set on search results.
+	Score *float64 `json:"score,omitempty" xml:"score,omitempty" bson:"score,omitempty"`
+	// Match is the fraction of the ingredients the user has, only set on
+	// suggestions.
+	Match *float64 `json:"match,omitempty" xml:"match,omitempty" bson:"match,omitempty"`
+	// Favorite tells whether the current user favorited the recipe, it is
+	// only set on single recipe reads and never stored with the recipe.
+	Favorite *bool `json:"favorite,omitempty" xml:"favorite,omitempty" bson:"-"`
+}
+
+// Image describes an uploaded picture stored on disk. Only the images of the
+// gallery have an ID, the first of them is the primary image.
+type Image struct {
+	ID          string `json:"id,omitempty" xml:"id,omitempty" bson:"id,omitempty"`
+	File        string `json:"-" xml:"-" bson:"file"`
+	ContentType string `json:"contentType" xml:"contentType" bson:"contentType"`
+	URL         string `json:"url" xml:"url" bson:"url"`
+}
+
+// FacetCount is the number of recipes sharing one value of a field.
+type FacetCount struct {
+	Value string `json:"value" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// Facets counts the recipes per cuisine and per tag, most common first.
+type Facets struct {
+	Cuisines []FacetCount `json:"cuisines" bson:"cuisines"`
+	Tags     []FacetCount `json:"tags" bson:"tags"`
+}
+
+// RecipeSync lists the changes since the time a client last synced. The
+// client passes SyncedAt as modifiedSince on its next sync.
+type RecipeSync struct {
+	XMLName  xml.Name  `json:"-" xml:"sync"`
+	Data     []Recipe  `json:"data" xml:"data>recipe"`
+	Deleted  []string  `json:"deleted" xml:"deleted>id"`
+	SyncedAt time.Time `json:"syncedAt" xml:"syncedAt"`
+}
+
+// RecipeList is a single page of recipes along with the paging details.
+type RecipeList struct {
+	XMLName    xml.Name `json:"-" xml:"recipes"`
+	Data       []Recipe `json:"data" xml:"data>recipe"`
+	Page       int64    `json:"page" xml:"page"`
+	Limit      int64    `json:"limit" xml:"limit"`
+	Total      int64    `json:"total" xml:"total"`
+	TotalPages int64    `json:"totalPages" xml:"totalPages"`
+}
+
+// RecipeCursorPage is a page of recipes read with a cursor. NextCursor is
+// passed as cursor to read the following page and is empty on the last one.
+type RecipeCursorPage struct {
+	XMLName    xml.Name `json:"-" xml:"recipes"`
+	Data       []Recipe `json:"data" xml:"data>recipe"`
+	Limit      int64    `json:"limit" xml:"limit"`
+	NextCursor string   `json:"nextCursor,omitempty" xml:"nextCursor,omitempty"`
+}
+
+// RecipeStats are the figures a recipe owner sees on their own recipes.
+type RecipeStats struct {
+	Views       int64   `json:"views" bson:"views"`
+	Favorites   int64   `json:"favorites" bson:"favorites"`
+	AvgRating   float64 `json:"avgRating" bson:"avgRating"`
+	RatingCount int64   `json:"ratingCount" bson:"ratingCount"`
+}
+
+// OwnedRecipe is a recipe along with its stats.
+type OwnedRecipe struct {
+	Recipe `bson:",inline"`
+	Stats  RecipeStats `json:"stats" bson:"stats"`
+}
+
+// OwnedRecipeList is a page of the recipes of the current user.
+type OwnedRecipeList struct {
+	Data       []OwnedRecipe `json:"data"`
+	Page       int64         `json:"page"`
+	Limit      int64         `json:"limit"`
+	Total      int64         `json:"total"`
+	TotalPages int64         `json:"totalPages"`
 }
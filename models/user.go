@@ -1,6 +1,28 @@
 package models
 
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
 type User struct {
-	Password string `json:"password"`
-	Username string `json:"username"`
+	ID       primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Password string             `json:"password" binding:"required"`
+	Username string             `json:"username" binding:"required"`
+	Email    string             `json:"email,omitempty" bson:"email,omitempty" binding:"omitempty,email"`
+	Roles    []string           `json:"roles,omitempty"`
+	OrgID    string             `json:"-" bson:"orgId,omitempty"`
+	// Unverified is set on sign up until the email address is confirmed.
+	// Accounts created before email verification don't have it.
+	Unverified bool `json:"-" bson:"unverified,omitempty"`
+}
+
+// Profile is the public view of a user, without any credentials.
+type Profile struct {
+	Username  string    `json:"username"`
+	Email     string    `json:"email,omitempty"`
+	Roles     []string  `json:"roles"`
+	OrgID     string    `json:"orgId"`
+	CreatedAt time.Time `json:"createdAt"`
 }
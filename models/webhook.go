@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook is an URL notified of writes on recipes. Deliveries are signed with
+// Secret, which is only shown when the webhook is registered.
+type Webhook struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	URL    string             `json:"url" bson:"url" binding:"required,url"`
+	Secret string             `json:"-" bson:"secret"`
+	// Events limits the actions delivered, all of them when empty.
+	Events    []string  `json:"events" bson:"events" binding:"dive,oneof=create update delete restore purge transfer"`
+	OrgID     string    `json:"orgId" bson:"orgId"`
+	CreatedBy string    `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// WebhookEvent is the body POSTed to webhooks.
+type WebhookEvent struct {
+	ID        string                 `json:"id"`
+	Action    string                 `json:"action"`
+	RecipeID  primitive.ObjectID     `json:"recipeId"`
+	OrgID     string                 `json:"orgId"`
+	Actor     string                 `json:"actor"`
+	Timestamp time.Time              `json:"timestamp"`
+	Recipe    map[string]interface{} `json:"recipe,omitempty"`
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	handlers "github.com/Jovdza012/gin_chapter_2/handlers"
+)
+
+// seedData holds the example recipes loaded by the seed command, shipped
+// with the binary.
+//
+//go:embed recipes.json
+var seedData embed.FS
+
+// seedRecipe is a recipe of the dataset. Its id is not an ObjectID, recipes
+// are matched by name instead.
+type seedRecipe struct {
+	Name         string    `json:"name"`
+	Tags         []string  `json:"tags"`
+	Ingredients  []string  `json:"ingredients"`
+	Instructions []string  `json:"instructions"`
+	PublishedAt  time.Time `json:"publishedAt"`
+}
+
+// seedCommand loads the example recipes and creates a demo admin account
+// owning them, for trying the API out. Recipes whose name is already taken
+// and an existing account are left alone, so seeding twice adds nothing.
+// Without --password a random one is generated and printed.
+func seedCommand(ctx context.Context, app *App, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	username := flags.String("username", "admin", "name of the demo admin account")
+	password := flags.String("password", "", "password of the demo admin account, random when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	policy := app.passwordPolicy()
+	generated := *password == ""
+	if generated {
+		var err error
+		if *password, err = randomPassword(policy); err != nil {
+			return err
+		}
+	}
+	err := insertUser(ctx, app.db, policy, *username, *password, "", handlers.DefaultOrg, []string{"admin"})
+	switch {
+	case errors.Is(err, errUserExists):
+		fmt.Printf("User %s already exists, left unchanged\n", *username)
+	case err != nil:
+		return err
+	case generated:
+		fmt.Printf("Created admin %s with password %s\n", *username, *password)
+	default:
+		fmt.Println("Created admin", *username)
+	}
+
+	data, err := seedData.ReadFile("recipes.json")
+	if err != nil {
+		return err
+	}
+	var recipes []seedRecipe
+	if err := json.Unmarshal(data, &recipes); err != nil {
+		return fmt.Errorf("invalid seed recipes: %w", err)
+	}
+	writes := make([]mongo.WriteModel, len(recipes))
+	for i, recipe := range recipes {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"name": recipe.Name, "orgId": handlers.DefaultOrg}).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{
+				"tags":         recipe.Tags,
+				"ingredients":  trimLines(recipe.Ingredients),
+				"instructions": trimLines(recipe.Instructions),
+				"publishedAt":  recipe.PublishedAt,
+				"createdAt":    recipe.PublishedAt,
+				"updatedAt":    recipe.PublishedAt,
+				"owner":        *username,
+				// the version of recipes created through the API
+				"version": int64(1),
+			}}).
+			SetUpsert(true)
+	}
+	result, err := app.db.Collection("recipes").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	if result.UpsertedCount > 0 {
+		// the API caches lists, drop them so the new recipes show up
+		if err := app.redisClient.Del("recipes", "recipes:facets").Err(); err != nil {
+			return fmt.Errorf("failed to invalidate the cache: %w", err)
+		}
+	}
+
+	fmt.Printf("Seeded %d recipes, %d already existed\n", result.UpsertedCount, int64(len(recipes))-result.UpsertedCount)
+	return nil
+}
+
+// trimLines strips the line endings the dataset has kept from its source.
+func trimLines(lines []string) []string {
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimSpace(line)
+	}
+	return trimmed
+}
+
+// randomPassword returns a random password following policy. The base64
+// alphabet has every character class but symbols, which only - and _ stand
+// for, so passwords missing any are drawn again.
+func randomPassword(policy handlers.PasswordPolicy) (string, error) {
+	buf := make([]byte, max(12, (policy.MinLength*3+3)/4))
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		if password := base64.RawURLEncoding.EncodeToString(buf); len(policy.Check(password)) == 0 {
+			return password, nil
+		}
+	}
+}